@@ -0,0 +1,333 @@
+package ruller
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+//Principal an authenticated caller
+type Principal struct {
+	//Name identifies the caller in authorization policies, audit logs and rules input ("_principal")
+	Name string `json:"name"`
+	//Method authentication method used. Ex.: "api-key", "hmac", "jwt", "client-cert"
+	Method string `json:"method"`
+	//Claims verified attributes of the caller (JWT claims, for example). Available to rules in "_claims"
+	Claims map[string]interface{} `json:"claims,omitempty"`
+}
+
+//Authenticator authenticates requests. Returns a nil principal and a nil error if the request doesn't carry credentials
+//handled by the authenticator, so that the next authenticator is tried. Returns an error if credentials are present but invalid
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+//AuthPolicy defines what each principal may access
+type AuthPolicy struct {
+	//Groups names of the groups each principal may evaluate and describe. Use "*" as principal for all authenticated principals and as group name for all groups
+	Groups map[string][]string `yaml:"groups" json:"groups"`
+	//Admins principals that may use admin endpoints (/admin/.., /metrics)
+	Admins []string `yaml:"admins" json:"admins"`
+}
+
+//publicPaths paths accessible without authentication
+var publicPaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+	"/ws":      true,
+}
+
+var (
+	authenticators = make([]Authenticator, 0)
+	authPolicy     *AuthPolicy
+	authMutex      sync.RWMutex
+)
+
+type principalKey struct{}
+
+//SetAuthenticators enables authentication with the authenticators, tried in order. Requests without valid credentials get 401.
+//Authentication is disabled if no authenticators are set
+func SetAuthenticators(auths ...Authenticator) {
+	authMutex.Lock()
+	defer authMutex.Unlock()
+	authenticators = auths
+}
+
+//SetAuthPolicy sets the authorization policy. Principals not allowed by it get 403. If nil, all authenticated principals may access everything
+func SetAuthPolicy(policy *AuthPolicy) {
+	authMutex.Lock()
+	defer authMutex.Unlock()
+	authPolicy = policy
+}
+
+//LoadAuthPolicy reads an authorization policy from a YAML or JSON file
+func LoadAuthPolicy(file string) (*AuthPolicy, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	policy := &AuthPolicy{}
+	err = yaml.UnmarshalStrict(data, policy)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid auth policy file %s. err=%s", file, err)
+	}
+	return policy, nil
+}
+
+//RequestPrincipal returns the principal authenticated for a request. Nil if authentication is disabled
+func RequestPrincipal(r *http.Request) *Principal {
+	p, _ := r.Context().Value(principalKey{}).(*Principal)
+	return p
+}
+
+//AllowsGroup whatever a principal may evaluate and describe a group
+func (p *AuthPolicy) AllowsGroup(principal string, groupName string) bool {
+	for _, name := range []string{principal, "*"} {
+		for _, g := range p.Groups[name] {
+			if g == "*" || g == groupName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//AllowsAdmin whatever a principal may use admin endpoints
+func (p *AuthPolicy) AllowsAdmin(principal string) bool {
+	for _, admin := range p.Admins {
+		if admin == principal || admin == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+//authenticate runs the authenticators and stores the principal in the request context. The principal is nil if authentication is disabled
+func authenticate(r *http.Request) (*http.Request, *Principal, error) {
+	authMutex.RLock()
+	auths := authenticators
+	authMutex.RUnlock()
+	if len(auths) == 0 {
+		return r, nil, nil
+	}
+	for _, a := range auths {
+		p, err := a.Authenticate(r)
+		if err != nil {
+			return r, nil, err
+		}
+		if p != nil {
+			return r.WithContext(context.WithValue(r.Context(), principalKey{}, p)), p, nil
+		}
+	}
+	return r, nil, fmt.Errorf("Missing credentials")
+}
+
+//authorize checks the policy for a group evaluation (groupName not empty), an admin operation or other operations (any authenticated principal)
+func authorize(p *Principal, groupName string, admin bool) error {
+	authMutex.RLock()
+	policy := authPolicy
+	authMutex.RUnlock()
+	if p == nil || policy == nil {
+		return nil
+	}
+	if admin && !policy.AllowsAdmin(p.Name) {
+		return fmt.Errorf("Principal '%s' is not allowed to use admin endpoints", p.Name)
+	}
+	if !admin && groupName != "" && !policy.AllowsGroup(p.Name, groupName) {
+		return fmt.Errorf("Principal '%s' is not allowed to access group '%s'", p.Name, groupName)
+	}
+	return nil
+}
+
+//groupAllowed returns whatever the principal of a request may access a group, for endpoints that list groups (/openapi.json, /info)
+func groupAllowed(r *http.Request) func(groupName string) bool {
+	p := RequestPrincipal(r)
+	return func(groupName string) bool {
+		return authorize(p, groupName, false) == nil
+	}
+}
+
+//AuthMiddleware authenticates requests and checks the authorization policy. Health endpoints and CORS preflights are not checked
+func AuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if publicPaths[r.URL.Path] || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		r, p, err := authenticate(r)
+		if err == errBodyTooLarge {
+			http.Error(w, fmt.Sprintf("Request body larger than %d bytes", inputLimits.MaxBodySize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			logrus.Debugf("Authentication failed for %s. err=%s", r.URL.Path, err)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+		admin := strings.HasPrefix(r.URL.Path, "/admin/") || r.URL.Path == "/metrics"
+		groupName := mux.Vars(r)["groupName"]
+		err = authorize(p, groupName, admin)
+		if err != nil {
+			logrus.Infof("Access denied to %s. err=%s", r.URL.Path, err)
+			http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//principalInput adds the authenticated principal to input ("_principal" and "_claims"). They are always set so that clients can't forge them in the request body
+func principalInput(r *http.Request, pinput map[string]interface{}) {
+	pinput["_principal"] = ""
+	pinput["_claims"] = map[string]interface{}{}
+	if p := RequestPrincipal(r); p != nil {
+		pinput["_principal"] = p.Name
+		if p.Claims != nil {
+			pinput["_claims"] = p.Claims
+		}
+	}
+}
+
+//APIKeyAuthenticator authenticates requests with the header "X-API-Key". Keys maps API keys to principal names
+type APIKeyAuthenticator struct {
+	Keys map[string]string
+}
+
+//LoadAPIKeys reads API keys from a YAML or JSON file in format {"[api key]": "[principal name]"}
+func LoadAPIKeys(file string) (*APIKeyAuthenticator, error) {
+	keys := make(map[string]string)
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	err = yaml.Unmarshal(data, &keys)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid API keys file %s. err=%s", file, err)
+	}
+	return &APIKeyAuthenticator{Keys: keys}, nil
+}
+
+//Authenticate checks the API key
+func (a *APIKeyAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		return nil, nil
+	}
+	for k, name := range a.Keys {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			return &Principal{Name: name, Method: "api-key"}, nil
+		}
+	}
+	return nil, fmt.Errorf("Invalid API key")
+}
+
+//HMACAuthenticator authenticates requests signed with a shared secret.
+//Requests must have the headers "X-Ruller-Date" (unix seconds) and "Authorization: HMAC-SHA256 keyId=[key id],signature=[base64 signature]".
+//The signature is the HMAC-SHA256 of "[method]\n[request uri]\n[date]\n[hex sha256 of body]", where the request uri is the path with the query string. The key id is the principal name.
+//Signed requests are not bound to a single use, so a captured request may be replayed within MaxSkew of its date. Use TLS so that requests can't be captured
+type HMACAuthenticator struct {
+	//Secrets secret of each key id
+	Secrets map[string]string
+	//MaxSkew max difference between the request date and the server clock, which is also how long a signed request may be replayed. Defaults to 5 minutes
+	MaxSkew time.Duration
+}
+
+//LoadHMACSecrets reads HMAC secrets from a YAML or JSON file in format {"[key id]": "[secret]"}
+func LoadHMACSecrets(file string) (*HMACAuthenticator, error) {
+	secrets := make(map[string]string)
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	err = yaml.Unmarshal(data, &secrets)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid HMAC secrets file %s. err=%s", file, err)
+	}
+	return &HMACAuthenticator{Secrets: secrets}, nil
+}
+
+//Authenticate verifies the request signature
+func (a *HMACAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "HMAC-SHA256 ") {
+		return nil, nil
+	}
+	params := make(map[string]string)
+	for _, part := range strings.Split(strings.TrimPrefix(authorization, "HMAC-SHA256 "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) == 2 {
+			params[kv[0]] = kv[1]
+		}
+	}
+	secret, exists := a.Secrets[params["keyId"]]
+	if !exists {
+		return nil, fmt.Errorf("Unknown HMAC key id")
+	}
+	signature, err := base64.StdEncoding.DecodeString(params["signature"])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid HMAC signature encoding")
+	}
+
+	date := r.Header.Get("X-Ruller-Date")
+	unix, err := strconv.ParseInt(date, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Header X-Ruller-Date must be unix seconds")
+	}
+	maxSkew := a.MaxSkew
+	if maxSkew == 0 {
+		maxSkew = 5 * time.Minute
+	}
+	skew := time.Since(time.Unix(unix, 0))
+	if skew > maxSkew || skew < -maxSkew {
+		return nil, fmt.Errorf("Request date too far from server time")
+	}
+
+	var body []byte
+	if r.Body != nil {
+		body, err = readBody(r.Body, r.ContentLength)
+		if err != nil {
+			return nil, err
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	expected := SignHMAC(secret, r.Method, r.URL.RequestURI(), date, body)
+	if !hmac.Equal(signature, expected) {
+		return nil, fmt.Errorf("Invalid HMAC signature")
+	}
+	return &Principal{Name: params["keyId"], Method: "hmac"}, nil
+}
+
+//SignHMAC computes the signature of a request for HMACAuthenticator. requestURI is the path with the query string, if any (as in "/rules/menu?_flatten=true")
+func SignHMAC(secret string, method string, requestURI string, date string, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + requestURI + "\n" + date + "\n" + hex.EncodeToString(bodyHash[:])))
+	return mac.Sum(nil)
+}
+
+//ClientCertAuthenticator authenticates requests with verified TLS client certificates (see ServerConfig.TLSClientCA). The principal name is the certificate Common Name
+type ClientCertAuthenticator struct{}
+
+//Authenticate uses the verified client certificate
+func (ClientCertAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, nil
+	}
+	return &Principal{Name: r.TLS.VerifiedChains[0][0].Subject.CommonName, Method: "client-cert"}, nil
+}
@@ -0,0 +1,152 @@
+package ruller
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthentication(t *testing.T) {
+	Add("auth", "rule1", func(ctx Context) (map[string]interface{}, error) {
+		return map[string]interface{}{"principal": ctx.Input["_principal"], "claims": ctx.Input["_claims"]}, nil
+	})
+	Add("auth-private", "rule1", func(ctx Context) (map[string]interface{}, error) {
+		return map[string]interface{}{"secret": true}, nil
+	})
+	dir, _ := ioutil.TempDir("", "ruller-auth")
+	defer os.RemoveAll(dir)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwks := fmt.Sprintf(`{"keys":[{"kid":"k1","kty":"EC","crv":"P-256","x":"%s","y":"%s"}]}`,
+		base64.RawURLEncoding.EncodeToString(key.X.Bytes()), base64.RawURLEncoding.EncodeToString(key.Y.Bytes()))
+	ioutil.WriteFile(filepath.Join(dir, "jwks.json"), []byte(jwks), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "policy.yml"), []byte("groups:\n  orders: [auth]\n  alice: ['*']\nadmins: [alice]\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "keys.yml"), []byte("key-orders: orders\nkey-alice: alice\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "secrets.yml"), []byte("orders: secret-orders\n"), 0644)
+
+	cfg := DefaultServerConfig()
+	cfg.AuthAPIKeysFile = filepath.Join(dir, "keys.yml")
+	cfg.AuthHMACSecretsFile = filepath.Join(dir, "secrets.yml")
+	cfg.AuthJWKSFile = filepath.Join(dir, "jwks.json")
+	cfg.AuthJWTAudience = "ruller"
+	cfg.AuthPolicyFile = filepath.Join(dir, "policy.yml")
+	err := configureAuth(cfg)
+	assert.Nil(t, err)
+	defer SetAuthenticators()
+	defer SetAuthPolicy(nil)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/rules/{groupName}", HandleRuleGroup).Methods("POST")
+	router.HandleFunc("/admin/rules/{groupName}/{ruleName}/enabled", HandleRuleEnabled).Methods("GET")
+	router.HandleFunc("/healthz", HandleHealth).Methods("GET")
+	router.HandleFunc("/info", HandleInfo).Methods("GET")
+	router.HandleFunc("/openapi.json", HandleOpenAPI).Methods("GET")
+	router.Use(AuthMiddleware)
+	call := func(method string, path string, body string, headers map[string]string) (int, map[string]interface{}) {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		output := make(map[string]interface{})
+		json.Unmarshal(w.Body.Bytes(), &output)
+		return w.Code, output
+	}
+
+	code, _ := call("GET", "/healthz", "", nil)
+	assert.Equal(t, 200, code)
+	code, _ = call("POST", "/rules/auth", `{"_flatten":true}`, nil)
+	assert.Equal(t, 401, code)
+	code, _ = call("POST", "/rules/auth", `{"_flatten":true}`, map[string]string{"X-API-Key": "wrong"})
+	assert.Equal(t, 401, code)
+
+	//api keys and policy
+	code, output := call("POST", "/rules/auth", `{"_flatten":true,"_principal":"alice"}`, map[string]string{"X-API-Key": "key-orders"})
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "orders", output["principal"])
+	code, _ = call("POST", "/rules/auth-private", `{}`, map[string]string{"X-API-Key": "key-orders"})
+	assert.Equal(t, 403, code)
+	code, _ = call("GET", "/admin/rules/auth/rule1/enabled", "", map[string]string{"X-API-Key": "key-orders"})
+	assert.Equal(t, 403, code)
+	code, _ = call("POST", "/rules/auth-private", `{}`, map[string]string{"X-API-Key": "key-alice"})
+	assert.Equal(t, 200, code)
+	code, _ = call("GET", "/admin/rules/auth/rule1/enabled", "", map[string]string{"X-API-Key": "key-alice"})
+	assert.Equal(t, 200, code)
+
+	//group lists only show the groups the principal may access
+	code, output = call("GET", "/info", "", map[string]string{"X-API-Key": "key-orders"})
+	assert.Equal(t, 200, code)
+	assert.Contains(t, output["groups"], "auth")
+	assert.NotContains(t, output["groups"], "auth-private")
+	code, output = call("GET", "/openapi.json", "", map[string]string{"X-API-Key": "key-orders"})
+	assert.Equal(t, 200, code)
+	assert.Contains(t, output["paths"], "/rules/auth")
+	assert.NotContains(t, output["paths"], "/rules/auth-private")
+	_, output = call("GET", "/info", "", map[string]string{"X-API-Key": "key-alice"})
+	assert.Contains(t, output["groups"], "auth-private")
+
+	//hmac
+	body := `{"_flatten":true}`
+	date := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := base64.StdEncoding.EncodeToString(SignHMAC("secret-orders", "POST", "/rules/auth", date, []byte(body)))
+	headers := map[string]string{"X-Ruller-Date": date, "Authorization": "HMAC-SHA256 keyId=orders,signature=" + signature}
+	code, output = call("POST", "/rules/auth", body, headers)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "orders", output["principal"])
+	code, _ = call("POST", "/rules/auth", `{"_flatten":false}`, headers)
+	assert.Equal(t, 401, code)
+	code, _ = call("POST", "/rules/auth?_flatten=false", body, headers)
+	assert.Equal(t, 401, code, "the query string is signed")
+	signature = base64.StdEncoding.EncodeToString(SignHMAC("secret-orders", "POST", "/rules/auth?a=1", date, []byte(body)))
+	code, _ = call("POST", "/rules/auth?a=1", body, map[string]string{"X-Ruller-Date": date, "Authorization": "HMAC-SHA256 keyId=orders,signature=" + signature})
+	assert.Equal(t, 200, code)
+	SetInputLimits(InputLimits{MaxBodySize: 10})
+	code, _ = call("POST", "/rules/auth", body, headers)
+	SetInputLimits(DefaultInputLimits())
+	assert.Equal(t, 413, code)
+
+	//jwt
+	token := signTestJWT(t, key, map[string]interface{}{"sub": "alice", "aud": "ruller", "role": "ops", "exp": time.Now().Add(time.Minute).Unix()})
+	code, output = call("POST", "/rules/auth", body, map[string]string{"Authorization": "Bearer " + token})
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "alice", output["principal"])
+	assert.Equal(t, "ops", output["claims"].(map[string]interface{})["role"])
+	expired := signTestJWT(t, key, map[string]interface{}{"sub": "alice", "aud": "ruller", "exp": time.Now().Add(-time.Minute).Unix()})
+	code, _ = call("POST", "/rules/auth", body, map[string]string{"Authorization": "Bearer " + expired})
+	assert.Equal(t, 401, code)
+	otherAudience := signTestJWT(t, key, map[string]interface{}{"sub": "alice", "aud": "other"})
+	code, _ = call("POST", "/rules/auth", body, map[string]string{"Authorization": "Bearer " + otherAudience})
+	assert.Equal(t, 401, code)
+	code, _ = call("POST", "/rules/auth", body, map[string]string{"Authorization": "Bearer " + token[:len(token)-4] + "AAAA"})
+	assert.Equal(t, 401, code)
+}
+
+func signTestJWT(t *testing.T, key *ecdsa.PrivateKey, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "ES256", "kid": "k1", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	assert.Nil(t, err)
+	signature := make([]byte, 64)
+	rb, sb := r.Bytes(), s.Bytes()
+	copy(signature[32-len(rb):32], rb)
+	copy(signature[64-len(sb):], sb)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
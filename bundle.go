@@ -0,0 +1,311 @@
+package ruller
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+//Bundle versioned export of the declarative rules of a group along with its input schema and settings, so that the group may be evaluated embedded in other services
+type Bundle struct {
+	Group string `json:"group"`
+	//Version hash of the bundle contents. Changes whenever rules, inputs, settings, overrides or kill switches of the group change
+	Version        string            `json:"version"`
+	Strategy       string            `json:"strategy"`
+	Flatten        bool              `json:"flatten"`
+	KeepFirst      bool              `json:"keepFirst"`
+	TreeOutput     bool              `json:"treeOutput"`
+	RequiredInputs map[string]string `json:"requiredInputs"`
+	//MergeStrategy name of the merge strategy of the group (see SetMergeStrategy), as in "sum" or "deepMerge". Empty if not set
+	MergeStrategy string `json:"mergeStrategy,omitempty"`
+	//KeyMergeStrategies names of the merge strategies of output keys (see SetKeyMergeStrategy)
+	KeyMergeStrategies map[string]string `json:"keyMergeStrategies,omitempty"`
+	//Outputs declared outputs (see DeclareOutput)
+	Outputs map[string]OutputDescription `json:"outputs,omitempty"`
+	//OutputValidation "log", "drop" or "reject" (see SetOutputValidation)
+	OutputValidation string `json:"outputValidation"`
+	//Overrides forced outputs in format [subject key][subject value]output (see SetOverride)
+	Overrides map[string]map[string]map[string]interface{} `json:"overrides,omitempty"`
+	//Rules enabled declarative rules, parents before children, in evaluation order
+	Rules []DeclarativeRule `json:"rules"`
+	//ServerOnly rules that can only be evaluated by the server (Go function rules and their descendants). They are not part of the bundle
+	ServerOnly []string `json:"serverOnly,omitempty"`
+}
+
+var (
+	//registryMutex guards the rule registry against groups replaced by LoadBundle while being processed
+	registryMutex sync.RWMutex
+	groupBundles  = make(map[string]string) //[group]version of the bundle loaded
+	bundleErrors  = make(map[string]error)  //[group]error of the last bundle load, if it failed
+)
+
+//ExportBundle exports the enabled declarative rules of a group along with its settings. Disabled rules (and their children) are left out.
+//Groups using custom merge strategies can't be exported, as they can't be evaluated the same way elsewhere
+func ExportBundle(groupName string) (*Bundle, error) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	rules, exists := groupRules[groupName]
+	if !exists {
+		return nil, notFoundError{fmt.Errorf("Group %s doesn't exist", groupName)}
+	}
+	defaultFlatten := groupFlatten[groupName]
+	defaultKeepFirst, exists := groupKeepFirst[groupName]
+	if !exists {
+		defaultKeepFirst = true
+	}
+	bundle := &Bundle{
+		Group:          groupName,
+		Strategy:       groupStrategy[groupName].String(),
+		Flatten:          defaultFlatten,
+		KeepFirst:        defaultKeepFirst,
+		TreeOutput:       groupTreeOutput[groupName],
+		RequiredInputs:   make(map[string]string),
+		OutputValidation: outputValidation[groupName].String(),
+		Rules:            make([]DeclarativeRule, 0),
+	}
+	for inputName, it := range requiredInputNames[groupName] {
+		bundle.RequiredInputs[inputName] = it.String()
+	}
+	if strategy, exists := groupMergeStrategy[groupName]; exists {
+		name, err := mergeStrategyName(strategy)
+		if err != nil {
+			return nil, fmt.Errorf("Group %s can't be exported. err=%s", groupName, err)
+		}
+		bundle.MergeStrategy = name
+	}
+	for key, strategy := range keyMergeStrategy[groupName] {
+		name, err := mergeStrategyName(strategy)
+		if err != nil {
+			return nil, fmt.Errorf("Group %s can't be exported. err=%s", groupName, err)
+		}
+		if bundle.KeyMergeStrategies == nil {
+			bundle.KeyMergeStrategies = make(map[string]string)
+		}
+		bundle.KeyMergeStrategies[key] = name
+	}
+	for outputName, decl := range outputSchemas[groupName] {
+		if bundle.Outputs == nil {
+			bundle.Outputs = make(map[string]OutputDescription)
+		}
+		bundle.Outputs[outputName] = OutputDescription{Type: decl.outputType.String(), Values: decl.values}
+	}
+	if overrides := GetOverrides(groupName); len(overrides) > 0 {
+		bundle.Overrides = overrides
+	}
+	exportRules(bundle, rules, false)
+	sort.Strings(bundle.ServerOnly)
+	exportDependencies(bundle)
+
+	b, err := json.Marshal(bundle)
+	if err != nil {
+		return nil, err
+	}
+	bundle.Version = fmt.Sprintf("%x", sha1.Sum(b))
+	return bundle, nil
+}
+
+func exportRules(bundle *Bundle, rules []*ruleInfo, serverOnly bool) {
+	for _, rinfo := range rules {
+		if !IsRuleEnabled(rinfo.groupName, rinfo.name) {
+			continue
+		}
+		ruleServerOnly := serverOnly || rinfo.declaration == nil
+		if ruleServerOnly {
+			bundle.ServerOnly = append(bundle.ServerOnly, rinfo.name)
+		} else {
+			drule := *rinfo.declaration
+			drule.Priority = rinfo.priority
+			drule.ChildrenStrategy = ""
+			if rinfo.childrenStrategy != EvaluateAll {
+				drule.ChildrenStrategy = rinfo.childrenStrategy.String()
+			}
+			drule.DependsOn = nil
+			for _, dinfo := range rinfo.dependencies {
+				drule.DependsOn = append(drule.DependsOn, dinfo.name)
+			}
+			bundle.Rules = append(bundle.Rules, drule)
+		}
+		exportRules(bundle, rinfo.children, ruleServerOnly)
+	}
+}
+
+//exportDependencies leaves out dependencies on rules that are not part of the bundle. Their outputs are not available to declarative rules anyway
+func exportDependencies(bundle *Bundle) {
+	exported := make(map[string]bool)
+	for _, drule := range bundle.Rules {
+		exported[drule.Name] = true
+	}
+	for i, drule := range bundle.Rules {
+		dependsOn := make([]string, 0, len(drule.DependsOn))
+		for _, dependencyName := range drule.DependsOn {
+			if exported[dependencyName] {
+				dependsOn = append(dependsOn, dependencyName)
+			}
+		}
+		bundle.Rules[i].DependsOn = nil
+		if len(dependsOn) > 0 {
+			bundle.Rules[i].DependsOn = dependsOn
+		}
+	}
+}
+
+//LoadBundle replaces all rules of the bundle group by the rules of the bundle. Safe to be called while the group is being processed.
+//If the bundle is invalid, the group is kept as is and reported as not ready in /readyz until a bundle is loaded successfully
+func LoadBundle(bundle *Bundle) error {
+	err := loadBundle(bundle)
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	if err != nil {
+		bundleErrors[bundle.Group] = err
+	} else {
+		delete(bundleErrors, bundle.Group)
+	}
+	return err
+}
+
+func loadBundle(bundle *Bundle) error {
+	strategy, err := parseStrategy(bundle.Strategy)
+	if err != nil {
+		return err
+	}
+	inputs := make(map[string]InputType)
+	for inputName, typeName := range bundle.RequiredInputs {
+		it, err := parseInputType(typeName)
+		if err != nil {
+			return err
+		}
+		inputs[inputName] = it
+	}
+	var mergeStrategy MergeStrategy
+	if bundle.MergeStrategy != "" {
+		mergeStrategy, err = parseMergeStrategy(bundle.MergeStrategy)
+		if err != nil {
+			return err
+		}
+	}
+	keyStrategies := make(map[string]MergeStrategy)
+	for key, name := range bundle.KeyMergeStrategies {
+		keyStrategies[key], err = parseMergeStrategy(name)
+		if err != nil {
+			return err
+		}
+	}
+	outputs := make(map[string]outputDeclaration)
+	for outputName, desc := range bundle.Outputs {
+		ot, err := parseInputType(desc.Type)
+		if err != nil {
+			return err
+		}
+		outputs[outputName] = outputDeclaration{outputType: ot, values: desc.Values}
+	}
+	validation, err := parseOutputValidation(bundle.OutputValidation)
+	if err != nil {
+		return err
+	}
+
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	groupName := bundle.Group
+	previousRules, existed := groupRules[groupName]
+	previousMap := rulesMap[groupName]
+	delete(groupRules, groupName)
+	delete(rulesMap, groupName)
+	err = addBundleRules(groupName, bundle.Rules)
+	if err != nil {
+		if existed {
+			groupRules[groupName] = previousRules
+			rulesMap[groupName] = previousMap
+		} else {
+			delete(groupRules, groupName)
+			delete(rulesMap, groupName)
+		}
+		groupRuleCount.DeleteLabelValues(groupName)
+		if existed {
+			groupRuleCount.WithLabelValues(groupName).Add(float64(len(previousMap)))
+		}
+		return fmt.Errorf("Invalid bundle for group %s. err=%s", groupName, err)
+	}
+	if _, exists := groupRules[groupName]; !exists {
+		groupRules[groupName] = make([]*ruleInfo, 0)
+	}
+	requiredInputNames[groupName] = inputs
+	groupStrategy[groupName] = strategy
+	groupFlatten[groupName] = bundle.Flatten
+	groupKeepFirst[groupName] = bundle.KeepFirst
+	groupTreeOutput[groupName] = bundle.TreeOutput
+	if mergeStrategy != nil {
+		groupMergeStrategy[groupName] = mergeStrategy
+	} else {
+		delete(groupMergeStrategy, groupName)
+	}
+	keyMergeStrategy[groupName] = keyStrategies
+	outputSchemas[groupName] = outputs
+	outputValidation[groupName] = validation
+	setGroupOverrides(groupName, bundle.Overrides)
+	groupBundles[groupName] = bundle.Version
+	groupRuleCount.DeleteLabelValues(groupName)
+	groupRuleCount.WithLabelValues(groupName).Add(float64(len(bundle.Rules)))
+	logrus.Infof("Loaded bundle %s of group %s with %d rules. server only rules: %v", bundle.Version, groupName, len(bundle.Rules), bundle.ServerOnly)
+	defer notifyGroupChanged(groupName)
+	return nil
+}
+
+func addBundleRules(groupName string, rules []DeclarativeRule) error {
+	for _, drule := range rules {
+		err := addDeclarative(groupName, drule)
+		if err != nil {
+			return err
+		}
+	}
+	return addDeclarativeDependencies(groupName, rules)
+}
+
+//BundleVersion returns the version of the bundle loaded for a group with LoadBundle. Empty if none
+func BundleVersion(groupName string) string {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	return groupBundles[groupName]
+}
+
+//HandleExportBundle renders the bundle of a group (GET /rules/{groupName}/bundle). The bundle version is used as ETag
+func HandleExportBundle(w http.ResponseWriter, r *http.Request) {
+	bundle, err := ExportBundle(mux.Vars(r)["groupName"])
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	etag := fmt.Sprintf("\"%s\"", bundle.Version)
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeJSON(w, bundle)
+}
+
+func parseInputType(name string) (InputType, error) {
+	for _, it := range []InputType{String, Float64, Bool, Object, Array} {
+		if it.String() == name {
+			return it, nil
+		}
+	}
+	return String, fmt.Errorf("Invalid input type '%s'", name)
+}
+
+func parseOutputValidation(name string) (OutputValidation, error) {
+	if name == "" {
+		return ValidationLog, nil
+	}
+	for _, v := range []OutputValidation{ValidationLog, ValidationDrop, ValidationReject} {
+		if v.String() == name {
+			return v, nil
+		}
+	}
+	return ValidationLog, fmt.Errorf("Invalid output validation '%s'", name)
+}
@@ -0,0 +1,312 @@
+//Package client is a Go client for ruller servers with timeouts, retries with backoff,
+//response caching honoring ETags, circuit breaking and static fallback outputs for when the server is unreachable
+package client
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+//ErrCircuitOpen returned (or passed to OnFallback) when calls are not being made because of too many consecutive failures
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+//Config client configuration. Zero values are replaced by defaults
+type Config struct {
+	//BaseURL ruller server address. Ex.: http://ruller:3000
+	BaseURL string
+	//HTTPClient used for calls. Defaults to a new http.Client
+	HTTPClient *http.Client
+	//Timeout of each attempt. Defaults to 2s
+	Timeout time.Duration
+	//MaxRetries number of retries after a failed attempt (network errors, 429 and 5xx). Defaults to 2. Use -1 for no retries
+	MaxRetries int
+	//InitialBackoff wait before the first retry. Doubles at each retry. Defaults to 100ms
+	InitialBackoff time.Duration
+	//MaxBackoff max wait between retries. Defaults to 2s
+	MaxBackoff time.Duration
+	//FailureThreshold consecutive failed evaluations that open the circuit breaker. Defaults to 5. Use -1 to disable the circuit breaker
+	FailureThreshold int
+	//OpenTimeout time the circuit breaker stays open before letting a trial call through. Defaults to 10s
+	OpenTimeout time.Duration
+	//MaxCacheEntries max number of outputs kept for ETag revalidation. Defaults to 1000. Use -1 to disable caching
+	MaxCacheEntries int
+	//Fallback static outputs by group name, returned when the server is unreachable
+	Fallback map[string]map[string]interface{}
+	//OnFallback called whenever a fallback output is returned instead of an error
+	OnFallback func(groupName string, err error)
+}
+
+//Client ruller client. Safe for concurrent use
+type Client struct {
+	config Config
+
+	mutex               sync.Mutex
+	consecutiveFailures int
+	openUntil           time.Time
+	cache               map[string]*list.Element
+	cacheOrder          *list.List
+}
+
+type cacheEntry struct {
+	key  string
+	etag string
+	//body kept instead of the decoded output, so that callers changing their outputs don't change the cache
+	body []byte
+}
+
+//StatusError returned when the server responds with an unexpected status
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("ruller returned status %d: %s", e.StatusCode, e.Message)
+}
+
+//New creates a client
+func New(config Config) *Client {
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{}
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 2 * time.Second
+	}
+	if config.MaxRetries == 0 {
+		config.MaxRetries = 2
+	}
+	if config.InitialBackoff == 0 {
+		config.InitialBackoff = 100 * time.Millisecond
+	}
+	if config.MaxBackoff == 0 {
+		config.MaxBackoff = 2 * time.Second
+	}
+	if config.FailureThreshold == 0 {
+		config.FailureThreshold = 5
+	}
+	if config.OpenTimeout == 0 {
+		config.OpenTimeout = 10 * time.Second
+	}
+	if config.MaxCacheEntries == 0 {
+		config.MaxCacheEntries = 1000
+	}
+	config.BaseURL = strings.TrimSuffix(config.BaseURL, "/")
+	return &Client{
+		config:     config,
+		cache:      make(map[string]*list.Element),
+		cacheOrder: list.New(),
+	}
+}
+
+//Evaluate processes the rules of a group with input. If the server is unreachable and a fallback output is configured for the group, it is returned instead of the error
+func (c *Client) Evaluate(ctx context.Context, groupName string, input map[string]interface{}) (map[string]interface{}, error) {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+	cacheKey := fmt.Sprintf("%s:%x", groupName, sha1.Sum(body))
+
+	if !c.allowCall() {
+		return c.fallback(groupName, ErrCircuitOpen)
+	}
+
+	backoff := c.config.InitialBackoff
+	for attempt := 0; ; attempt++ {
+		output, err := c.call(ctx, groupName, body, cacheKey)
+		if err == nil {
+			c.recordResult(true)
+			return output, nil
+		}
+		if !retryable(err) {
+			//the server answered. the request itself is the problem
+			c.recordResult(true)
+			return nil, err
+		}
+		if attempt >= c.config.MaxRetries || ctx.Err() != nil {
+			c.recordResult(false)
+			return c.fallback(groupName, err)
+		}
+		logrus.Debugf("ruller call failed. retrying in %s. err=%s", backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			c.recordResult(false)
+			return c.fallback(groupName, ctx.Err())
+		}
+		backoff *= 2
+		if backoff > c.config.MaxBackoff {
+			backoff = c.config.MaxBackoff
+		}
+	}
+}
+
+func (c *Client) call(ctx context.Context, groupName string, body []byte, cacheKey string) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+	return c.request(ctx, groupName, body, cacheKey, true)
+}
+
+//request posts an evaluation. If conditional, the ETag of the cached output is sent in "If-None-Match"
+func (c *Client) request(ctx context.Context, groupName string, body []byte, cacheKey string, conditional bool) (map[string]interface{}, error) {
+	req, err := http.NewRequest("POST", c.config.BaseURL+"/rules/"+url.PathEscape(groupName), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	var cached *cacheEntry
+	if conditional {
+		cached = c.cached(cacheKey)
+	}
+	if cached != nil {
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+
+	resp, err := c.config.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		logrus.Debugf("ruller output for group %s not modified", groupName)
+		respBody = cached.body
+	} else if resp.StatusCode == http.StatusNotModified && conditional {
+		//nothing cached to reuse (the server or a proxy answered 304 anyway). ask for the output again
+		logrus.Debugf("ruller output for group %s not modified, but not cached. requesting it again", groupName)
+		return c.request(ctx, groupName, body, cacheKey, false)
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(respBody))}
+	}
+
+	output := make(map[string]interface{})
+	err = json.Unmarshal(respBody, &output)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid ruller response. err=%s", err)
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" && resp.StatusCode == http.StatusOK {
+		c.store(&cacheEntry{key: cacheKey, etag: etag, body: respBody})
+	}
+	return output, nil
+}
+
+func retryable(err error) bool {
+	se, ok := err.(*StatusError)
+	if !ok {
+		return true
+	}
+	return se.StatusCode == http.StatusTooManyRequests || se.StatusCode >= 500
+}
+
+func (c *Client) fallback(groupName string, err error) (map[string]interface{}, error) {
+	output, exists := c.config.Fallback[groupName]
+	if !exists {
+		return nil, err
+	}
+	logrus.Warnf("Using fallback output for group %s. err=%s", groupName, err)
+	if c.config.OnFallback != nil {
+		c.config.OnFallback(groupName, err)
+	}
+	//callers may change the output, but not the configured fallback
+	return deepCopy(output).(map[string]interface{}), nil
+}
+
+//deepCopy copies maps and slices of decoded outputs recursively. Other values are returned as they are
+func deepCopy(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, mv := range value {
+			m[k] = deepCopy(mv)
+		}
+		return m
+	case []interface{}:
+		arr := make([]interface{}, len(value))
+		for i, av := range value {
+			arr[i] = deepCopy(av)
+		}
+		return arr
+	}
+	return v
+}
+
+func (c *Client) allowCall() bool {
+	if c.config.FailureThreshold < 0 {
+		return true
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.consecutiveFailures < c.config.FailureThreshold {
+		return true
+	}
+	if time.Now().Before(c.openUntil) {
+		return false
+	}
+	//half open: let this call through and keep others out until it finishes
+	c.openUntil = time.Now().Add(c.config.OpenTimeout)
+	return true
+}
+
+func (c *Client) recordResult(success bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if success {
+		c.consecutiveFailures = 0
+		return
+	}
+	c.consecutiveFailures++
+	if c.config.FailureThreshold > 0 && c.consecutiveFailures >= c.config.FailureThreshold {
+		c.openUntil = time.Now().Add(c.config.OpenTimeout)
+	}
+}
+
+func (c *Client) cached(key string) *cacheEntry {
+	if c.config.MaxCacheEntries < 0 {
+		return nil
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	elem, exists := c.cache[key]
+	if !exists {
+		return nil
+	}
+	c.cacheOrder.MoveToFront(elem)
+	return elem.Value.(*cacheEntry)
+}
+
+func (c *Client) store(entry *cacheEntry) {
+	if c.config.MaxCacheEntries < 0 {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if elem, exists := c.cache[entry.key]; exists {
+		elem.Value = entry
+		c.cacheOrder.MoveToFront(elem)
+		return
+	}
+	c.cache[entry.key] = c.cacheOrder.PushFront(entry)
+	for c.cacheOrder.Len() > c.config.MaxCacheEntries {
+		oldest := c.cacheOrder.Back()
+		c.cacheOrder.Remove(oldest)
+		delete(c.cache, oldest.Value.(*cacheEntry).key)
+	}
+}
@@ -0,0 +1,121 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/flaviostutz/ruller"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvaluateWithCache(t *testing.T) {
+	ruller.AddRequiredInput("client", "name", ruller.String)
+	ruller.SetDefaultFlatten("client", true)
+	ruller.Add("client", "rule1", func(ctx ruller.Context) (map[string]interface{}, error) {
+		return map[string]interface{}{"greeting": "hello " + ctx.Input["name"].(string)}, nil
+	})
+	var calls, notModified int32
+	router := mux.NewRouter()
+	router.HandleFunc("/rules/{groupName}", ruller.HandleRuleGroup).Methods("POST")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.Header.Get("If-None-Match") != "" {
+			atomic.AddInt32(&notModified, 1)
+		}
+		router.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	c := New(Config{BaseURL: server.URL})
+	output, err := c.Evaluate(context.Background(), "client", map[string]interface{}{"name": "joe"})
+	assert.Nil(t, err)
+	assert.Equal(t, "hello joe", output["greeting"])
+
+	output["greeting"] = "changed by caller"
+
+	output, err = c.Evaluate(context.Background(), "client", map[string]interface{}{"name": "joe"})
+	assert.Nil(t, err)
+	assert.Equal(t, "hello joe", output["greeting"])
+	assert.Equal(t, int32(2), calls)
+	assert.Equal(t, int32(1), notModified)
+
+	output["greeting"] = "changed by caller"
+	output, err = c.Evaluate(context.Background(), "client", map[string]interface{}{"name": "joe"})
+	assert.Nil(t, err)
+	assert.Equal(t, "hello joe", output["greeting"])
+
+	_, err = c.Evaluate(context.Background(), "client", map[string]interface{}{"name": "joe", "_flatten": "maybe"})
+	assert.NotNil(t, err)
+	assert.Equal(t, int32(4), calls, "invalid requests must not be retried")
+}
+
+func TestRetriesAndFallback(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			http.Error(w, "unavailable", 503)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"beta":true}`))
+	}))
+	defer server.Close()
+
+	c := New(Config{BaseURL: server.URL, MaxRetries: 2, InitialBackoff: time.Millisecond})
+	output, err := c.Evaluate(context.Background(), "test", map[string]interface{}{})
+	assert.Nil(t, err)
+	assert.Equal(t, true, output["beta"])
+	assert.Equal(t, int32(3), calls)
+
+	var fallbackErr error
+	c = New(Config{
+		BaseURL:          "http://127.0.0.1:1",
+		MaxRetries:       -1,
+		FailureThreshold: 2,
+		OpenTimeout:      time.Hour,
+		Fallback:         map[string]map[string]interface{}{"test": {"beta": false, "menu": map[string]interface{}{"items": []interface{}{"home"}}}},
+		OnFallback:       func(groupName string, err error) { fallbackErr = err },
+	})
+	for i := 0; i < 2; i++ {
+		output, err = c.Evaluate(context.Background(), "test", map[string]interface{}{})
+		assert.Nil(t, err)
+		assert.Equal(t, false, output["beta"])
+		assert.Equal(t, []interface{}{"home"}, output["menu"].(map[string]interface{})["items"])
+		assert.NotNil(t, fallbackErr)
+		//changes made by callers don't leak into the fallback
+		output["menu"].(map[string]interface{})["items"].([]interface{})[0] = "changed"
+		output["menu"].(map[string]interface{})["title"] = "changed"
+	}
+
+	output, err = c.Evaluate(context.Background(), "test", map[string]interface{}{})
+	assert.Nil(t, err)
+	assert.Equal(t, ErrCircuitOpen, fallbackErr)
+
+	_, err = c.Evaluate(context.Background(), "other", map[string]interface{}{})
+	assert.Equal(t, ErrCircuitOpen, err)
+}
+
+func TestNotModifiedWithoutCache(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		assert.Empty(t, r.Header.Get("If-None-Match"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"beta":true}`))
+	}))
+	defer server.Close()
+
+	c := New(Config{BaseURL: server.URL, MaxRetries: -1})
+	output, err := c.Evaluate(context.Background(), "test", map[string]interface{}{})
+	assert.Nil(t, err)
+	assert.Equal(t, true, output["beta"])
+	assert.Equal(t, int32(2), calls)
+}
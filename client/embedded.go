@@ -0,0 +1,117 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/flaviostutz/ruller"
+	"github.com/sirupsen/logrus"
+)
+
+//Embedded evaluates the declarative rules of a group in-process, with the same semantics of ruller.Process.
+//The group bundle is downloaded from the ruller server and refreshed in background. Go function rules are server only and are not evaluated
+type Embedded struct {
+	client    *Client
+	groupName string
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+//Embed downloads the bundle of a group, loads it and refreshes it every 'refresh' (if greater than zero) until Close is called or ctx is done.
+//Fails if the bundle can't be downloaded. Refresh failures are logged and the current bundle is kept
+func (c *Client) Embed(ctx context.Context, groupName string, refresh time.Duration) (*Embedded, error) {
+	e := &Embedded{client: c, groupName: groupName, done: make(chan struct{})}
+	_, err := e.Refresh(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx, e.cancel = context.WithCancel(ctx)
+	go func() {
+		defer close(e.done)
+		if refresh <= 0 {
+			<-ctx.Done()
+			return
+		}
+		ticker := time.NewTicker(refresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				_, err := e.Refresh(ctx)
+				if err != nil {
+					logrus.Warnf("Couldn't refresh bundle of group %s. Keeping version %s. err=%s", groupName, ruller.BundleVersion(groupName), err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return e, nil
+}
+
+//Evaluate processes the rules of the group locally. Special attributes ("_flatten", "_fields" etc) are honored as in the REST API
+func (e *Embedded) Evaluate(input map[string]interface{}) (map[string]interface{}, error) {
+	return ruller.Evaluate(e.groupName, input)
+}
+
+//Version returns the version of the bundle in use
+func (e *Embedded) Version() string {
+	return ruller.BundleVersion(e.groupName)
+}
+
+//Refresh downloads the bundle of the group and loads it if it changed. Returns true if a new version was loaded
+func (e *Embedded) Refresh(ctx context.Context) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.client.config.Timeout)
+	defer cancel()
+	req, err := http.NewRequest("GET", e.client.config.BaseURL+"/rules/"+url.PathEscape(e.groupName)+"/bundle", nil)
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	version := ruller.BundleVersion(e.groupName)
+	if version != "" {
+		req.Header.Set("If-None-Match", fmt.Sprintf("\"%s\"", version))
+	}
+
+	resp, err := e.client.config.HTTPClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, &StatusError{StatusCode: resp.StatusCode, Message: string(body)}
+	}
+
+	bundle := &ruller.Bundle{}
+	err = json.Unmarshal(body, bundle)
+	if err != nil {
+		return false, fmt.Errorf("Invalid bundle. err=%s", err)
+	}
+	if bundle.Version == version {
+		return false, nil
+	}
+	err = ruller.LoadBundle(bundle)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+//Close stops refreshing the bundle
+func (e *Embedded) Close() {
+	e.cancel()
+	<-e.done
+}
@@ -0,0 +1,43 @@
+package client
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/flaviostutz/ruller"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmbedded(t *testing.T) {
+	ruller.SetDefaultFlatten("embedded", true)
+	ruller.SetDefaultTreeOutput("embedded", true)
+	err := ruller.AddDeclarative("embedded", ruller.DeclarativeRule{
+		Name:   "beta",
+		When:   []ruller.Condition{{Attribute: "userId", Operator: "eq", Value: "123"}},
+		Output: map[string]interface{}{"beta": true},
+	})
+	assert.Nil(t, err)
+	router := mux.NewRouter()
+	router.HandleFunc("/rules/{groupName}/bundle", ruller.HandleExportBundle).Methods("GET")
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	c := New(Config{BaseURL: server.URL})
+	e, err := c.Embed(context.Background(), "embedded", 0)
+	assert.Nil(t, err)
+	defer e.Close()
+	assert.NotEmpty(t, e.Version())
+
+	output, err := e.Evaluate(map[string]interface{}{"userId": "123"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"beta": true}, output)
+
+	changed, err := e.Refresh(context.Background())
+	assert.Nil(t, err)
+	assert.False(t, changed)
+
+	_, err = c.Embed(context.Background(), "embedded-unknown", 0)
+	assert.NotNil(t, err)
+}
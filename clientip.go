@@ -0,0 +1,127 @@
+package ruller
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+//PrivateNetworks loopback and private networks. Use them as trusted proxies only if every host in these networks is a proxy, as any of them could otherwise forge client addresses
+var PrivateNetworks = []string{"127.0.0.0/8", "::1/128", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"}
+
+//trustedProxies none by default, so that forwarding headers are ignored until the proxies in front of the server are configured
+var trustedProxies = []*net.IPNet{}
+
+//forwardedHeader the only header read to find the addresses forwarded by proxies
+var forwardedHeader = "X-Forwarded-For"
+
+//SetForwardedHeader sets the header trusted proxies use to forward client addresses: "X-Forwarded-For" (default), "Forwarded" or "X-Real-IP".
+//Other forwarding headers are ignored, as proxies usually pass them through unchanged from clients
+func SetForwardedHeader(header string) error {
+	switch http.CanonicalHeaderKey(header) {
+	case "X-Forwarded-For", "Forwarded", "X-Real-Ip":
+		forwardedHeader = http.CanonicalHeaderKey(header)
+		return nil
+	}
+	return fmt.Errorf("Invalid forwarded header '%s'. Use X-Forwarded-For, Forwarded or X-Real-IP", header)
+}
+
+//SetTrustedProxies sets the networks (CIDRs, as in "10.0.0.0/8", or single IPs) of the proxies whose forwarding headers are trusted to resolve client IPs.
+//Without arguments, forwarding headers are ignored and the IP of the direct requestor is used
+func SetTrustedProxies(cidrs ...string) error {
+	nets, err := parseCIDRs(cidrs)
+	if err != nil {
+		return err
+	}
+	trustedProxies = nets
+	return nil
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("Invalid trusted proxy '%s'", cidr)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			cidr = fmt.Sprintf("%s/%d", cidr, bits)
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid trusted proxy '%s'", cidr)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func isTrustedProxy(ip net.IP) bool {
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+//clientIP resolves the IP of the client of a request. Returns the resolved IP (empty if unknown) and the chain of addresses
+//from the original client to the direct requestor, as reported by the forwarded header (see SetForwardedHeader).
+//The chain is walked from right to left, skipping trusted proxies, so that addresses added by untrusted parties are never used
+func clientIP(r *http.Request) (string, []string) {
+	chain := append(forwardedFor(r), r.RemoteAddr)
+	for i, addr := range chain {
+		chain[i] = normalizeIP(addr)
+	}
+
+	resolved := ""
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip := net.ParseIP(chain[i])
+		if ip == nil {
+			break
+		}
+		resolved = chain[i]
+		if !isTrustedProxy(ip) {
+			break
+		}
+	}
+	return resolved, chain
+}
+
+//forwardedFor addresses from the forwarded header, from the original client to the last proxy
+func forwardedFor(r *http.Request) []string {
+	addrs := make([]string, 0)
+	for _, value := range r.Header.Values(forwardedHeader) {
+		for _, element := range strings.Split(value, ",") {
+			if forwardedHeader != "Forwarded" {
+				addrs = append(addrs, element)
+				continue
+			}
+			for _, pair := range strings.Split(element, ";") {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) == 2 && strings.EqualFold(kv[0], "for") {
+					addrs = append(addrs, strings.Trim(kv[1], "\""))
+				}
+			}
+		}
+	}
+	return addrs
+}
+
+//normalizeIP removes ports and brackets from addresses such as "1.2.3.4:80" and "[2001:db8::1]:80". Valid IPs are returned in canonical form
+func normalizeIP(addr string) string {
+	addr = strings.TrimSpace(addr)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	addr = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	if ip := net.ParseIP(addr); ip != nil {
+		return ip.String()
+	}
+	return addr
+}
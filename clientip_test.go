@@ -0,0 +1,92 @@
+package ruller
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIP(t *testing.T) {
+	resolve := func(remoteAddr string, headers map[string][]string) (string, []string) {
+		r, _ := http.NewRequest("POST", "/", nil)
+		r.RemoteAddr = remoteAddr
+		for k, vs := range headers {
+			for _, v := range vs {
+				r.Header.Add(k, v)
+			}
+		}
+		return clientIP(r)
+	}
+
+	//no proxies are trusted by default, not even private networks
+	ip, _ := resolve("10.0.0.1:5000", map[string][]string{"X-Forwarded-For": {"5.6.7.8"}})
+	assert.Equal(t, "10.0.0.1", ip)
+
+	assert.NotNil(t, SetTrustedProxies("10.0.0.0/33"))
+	assert.Nil(t, SetTrustedProxies("10.0.0.0/8", "2001:db8::1"))
+	defer SetTrustedProxies()
+
+	ip, chain := resolve("1.2.3.4:5000", nil)
+	assert.Equal(t, "1.2.3.4", ip)
+	assert.Equal(t, []string{"1.2.3.4"}, chain)
+
+	ip, _ = resolve("[2001:db8::5]:5000", nil)
+	assert.Equal(t, "2001:db8::5", ip)
+
+	//headers from untrusted requestors are ignored
+	ip, chain = resolve("1.2.3.4:5000", map[string][]string{"X-Forwarded-For": {"5.6.7.8"}})
+	assert.Equal(t, "1.2.3.4", ip)
+	assert.Equal(t, []string{"5.6.7.8", "1.2.3.4"}, chain)
+
+	//right to left, skipping trusted proxies only
+	ip, chain = resolve("10.0.0.1:5000", map[string][]string{"X-Forwarded-For": {"9.9.9.9, 5.6.7.8", "10.0.0.2"}})
+	assert.Equal(t, "5.6.7.8", ip)
+	assert.Equal(t, []string{"9.9.9.9", "5.6.7.8", "10.0.0.2", "10.0.0.1"}, chain)
+
+	ip, _ = resolve("10.0.0.1:5000", map[string][]string{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}})
+	assert.Equal(t, "10.0.0.3", ip)
+
+	ip, _ = resolve("10.0.0.1:5000", map[string][]string{"X-Forwarded-For": {"garbage, 10.0.0.2"}})
+	assert.Equal(t, "10.0.0.2", ip)
+
+	//only the configured header is read, even when a trusted proxy passes others through
+	both := map[string][]string{"X-Forwarded-For": {"203.0.113.9"}, "Forwarded": {"for=6.6.6.6"}}
+	ip, chain = resolve("10.0.0.5:5000", both)
+	assert.Equal(t, "203.0.113.9", ip)
+	assert.Equal(t, []string{"203.0.113.9", "10.0.0.5"}, chain)
+
+	assert.NotNil(t, SetForwardedHeader("X-Client-IP"))
+	assert.Nil(t, SetForwardedHeader("Forwarded"))
+	defer SetForwardedHeader("X-Forwarded-For")
+	ip, _ = resolve("10.0.0.5:5000", both)
+	assert.Equal(t, "6.6.6.6", ip)
+
+	ip, chain = resolve("[2001:db8::1]:443", map[string][]string{"Forwarded": {`for="[2001:db8:cafe::17]:4711";proto=https, for=10.0.0.2`}, "X-Forwarded-For": {"6.6.6.6"}})
+	assert.Equal(t, "2001:db8:cafe::17", ip)
+	assert.Equal(t, []string{"2001:db8:cafe::17", "10.0.0.2", "2001:db8::1"}, chain)
+
+	assert.Nil(t, SetForwardedHeader("x-real-ip"))
+	ip, _ = resolve("10.0.0.1:5000", map[string][]string{"X-Real-Ip": {"5.6.7.8"}, "X-Forwarded-For": {"6.6.6.6"}})
+	assert.Equal(t, "5.6.7.8", ip)
+	assert.Nil(t, SetForwardedHeader("X-Forwarded-For"))
+
+	Add("clientip", "rule1", func(ctx Context) (map[string]interface{}, error) {
+		return map[string]interface{}{"ip": ctx.Input["_remote_ip"], "chain": ctx.Input["_ip_chain"]}, nil
+	})
+	r, _ := http.NewRequest("POST", "/rules/clientip", bytes.NewBufferString(`{"_flatten":true}`))
+	r.RemoteAddr = "10.0.0.1:5000"
+	r.Header.Set("X-Forwarded-For", "5.6.7.8:1234")
+	r = mux.SetURLVars(r, map[string]string{"groupName": "clientip"})
+	w := httptest.NewRecorder()
+	HandleRuleGroup(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	output := make(map[string]interface{})
+	json.Unmarshal(w.Body.Bytes(), &output)
+	assert.Equal(t, "5.6.7.8", output["ip"])
+	assert.Equal(t, []interface{}{"5.6.7.8", "10.0.0.1"}, output["chain"])
+}
@@ -0,0 +1,159 @@
+package ruller
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"sort"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+var goTypes = map[InputType]string{
+	String:  "string",
+	Float64: "float64",
+	Bool:    "bool",
+	Object:  "map[string]interface{}",
+	Array:   "[]interface{}",
+}
+
+var clientTemplate = template.Must(template.New("client").Parse(`// Code generated by ruller. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+{{range .Groups}}
+//{{.TypeName}}Output output of rule group '{{.Name}}'
+type {{.TypeName}}Output struct {
+{{- range .Fields}}
+	{{.FieldName}} *{{.GoType}} ` + "`json:\"{{.Name}},omitempty\"`" + `
+{{- end}}
+}
+{{range .Fields}}{{$field := .}}{{range .Values}}
+//{{$field.TypeName}}{{.Const}} allowed value for '{{$field.Name}}'
+const {{$field.TypeName}}{{.Const}} = {{printf "%q" .Value}}
+{{end}}{{end}}
+//Evaluate{{.TypeName}} evaluates rule group '{{.Name}}' at a ruller server with flatten output
+func Evaluate{{.TypeName}}(ctx context.Context, client *http.Client, baseURL string, input map[string]interface{}) (*{{.TypeName}}Output, error) {
+	body := map[string]interface{}{"_flatten": true}
+	for k, v := range input {
+		body[k] = v
+	}
+	bodyBytes, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", baseURL+"/rules/"+{{printf "%q" .Name}}, bytes.NewReader(bodyBytes))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ruller returned status %d", resp.StatusCode)
+	}
+	output := {{.TypeName}}Output{}
+	err = json.NewDecoder(resp.Body).Decode(&output)
+	if err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
+{{end}}`))
+
+type clientGroup struct {
+	Name     string
+	TypeName string
+	Fields   []clientField
+}
+
+type clientField struct {
+	Name      string
+	FieldName string
+	TypeName  string
+	GoType    string
+	Values    []clientValue
+}
+
+type clientValue struct {
+	Const string
+	Value string
+}
+
+//GenerateGoClient generates the source of a typed Go client for the declared outputs (see DeclareOutput) of the groups
+func GenerateGoClient(packageName string, groupNames ...string) ([]byte, error) {
+	groups := make([]clientGroup, 0, len(groupNames))
+	for _, groupName := range groupNames {
+		gs, exists := outputSchemas[groupName]
+		if !exists {
+			return nil, fmt.Errorf("Group %s has no declared outputs", groupName)
+		}
+		group := clientGroup{Name: groupName, TypeName: goIdentifier(groupName)}
+		for outputName, decl := range gs {
+			field := clientField{
+				Name:      outputName,
+				FieldName: goIdentifier(outputName),
+				TypeName:  group.TypeName + goIdentifier(outputName),
+				GoType:    goTypes[decl.outputType],
+			}
+			consts := make(map[string]bool)
+			for _, v := range decl.values {
+				field.Values = append(field.Values, clientValue{Const: uniqueIdentifier(goIdentifier(v), consts), Value: v})
+			}
+			group.Fields = append(group.Fields, field)
+		}
+		sort.Slice(group.Fields, func(i, j int) bool { return group.Fields[i].Name < group.Fields[j].Name })
+		groups = append(groups, group)
+	}
+
+	var buf bytes.Buffer
+	err := clientTemplate.Execute(&buf, map[string]interface{}{"Package": packageName, "Groups": groups})
+	if err != nil {
+		return nil, err
+	}
+	return format.Source(buf.Bytes())
+}
+
+//goIdentifier converts names such as "show-banner" or "rule1.1" to exported Go identifiers ("ShowBanner", "Rule11")
+func goIdentifier(name string) string {
+	var sb strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		sb.WriteRune(r)
+	}
+	id := sb.String()
+	if id == "" || !unicode.IsLetter([]rune(id)[0]) {
+		id = "X" + id
+	}
+	return id
+}
+
+//uniqueIdentifier adds a numeric suffix to identifiers already used, as values such as "a-b" and "a b" convert to the same identifier
+func uniqueIdentifier(id string, used map[string]bool) string {
+	unique := id
+	for i := 2; used[unique]; i++ {
+		unique = fmt.Sprintf("%s%d", id, i)
+	}
+	used[unique] = true
+	return unique
+}
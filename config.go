@@ -0,0 +1,248 @@
+package ruller
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+//ServerConfig configuration of the built-in server. See DefaultServerConfig and LoadServerConfig
+type ServerConfig struct {
+	//ListenAddress REST and gRPC API listen ip address
+	ListenAddress string `yaml:"listen-address" json:"listen-address"`
+	//ListenPort REST API listen port
+	ListenPort int `yaml:"listen-port" json:"listen-port"`
+	//GRPCPort gRPC API listen port. If 0, the gRPC API is disabled
+	GRPCPort int `yaml:"grpc-port" json:"grpc-port"`
+	//LogLevel debug, info, warning or error
+	LogLevel string `yaml:"log-level" json:"log-level"`
+	//GeoLite2DB Geolite mmdb database file. If empty, localization info based on IP is disabled
+	GeoLite2DB string `yaml:"geolite2-db" json:"geolite2-db"`
+	//CityStateDB City->State database file in CSV format 'country-code,city,state'
+	CityStateDB string `yaml:"city-state-db" json:"city-state-db"`
+	//RuleStateFile file used to persist rules enabled/disabled at runtime. If empty, rule states are kept in memory only
+	RuleStateFile string `yaml:"rule-state-file" json:"rule-state-file"`
+	//TLSCert certificate file (PEM). When defined along with TLSKey, REST and gRPC APIs are served with TLS. Files are reloaded when changed
+	TLSCert string `yaml:"tls-cert" json:"tls-cert"`
+	//TLSKey private key file (PEM) of TLSCert
+	TLSKey string `yaml:"tls-key" json:"tls-key"`
+	//TLSClientCA CA bundle file (PEM) used to verify client certificates (mutual TLS)
+	TLSClientCA string `yaml:"tls-client-ca" json:"tls-client-ca"`
+	//TLSClientOptional accept clients without certificates when TLSClientCA is defined. Certificates sent are still verified
+	TLSClientOptional bool `yaml:"tls-client-optional" json:"tls-client-optional"`
+	//AuthAPIKeysFile YAML or JSON file with API keys in format {"[api key]": "[principal]"}. Enables API key authentication
+	AuthAPIKeysFile string `yaml:"auth-api-keys-file" json:"auth-api-keys-file"`
+	//AuthHMACSecretsFile YAML or JSON file with HMAC secrets in format {"[key id]": "[secret]"}. Enables HMAC signed requests authentication
+	AuthHMACSecretsFile string `yaml:"auth-hmac-secrets-file" json:"auth-hmac-secrets-file"`
+	//AuthJWKSFile JWKS file used to verify JWT bearer tokens. Enables JWT authentication
+	AuthJWKSFile string `yaml:"auth-jwks-file" json:"auth-jwks-file"`
+	//AuthJWTIssuer required "iss" claim of JWTs
+	AuthJWTIssuer string `yaml:"auth-jwt-issuer" json:"auth-jwt-issuer"`
+	//AuthJWTAudience required "aud" claim of JWTs
+	AuthJWTAudience string `yaml:"auth-jwt-audience" json:"auth-jwt-audience"`
+	//AuthClientCert authenticate clients by their verified TLS certificates (see TLSClientCA)
+	AuthClientCert bool `yaml:"auth-client-cert" json:"auth-client-cert"`
+	//AuthPolicyFile YAML or JSON file with the authorization policy (see AuthPolicy)
+	AuthPolicyFile string `yaml:"auth-policy-file" json:"auth-policy-file"`
+	//TrustedProxies comma separated networks (CIDRs) of proxies whose forwarding headers are trusted to resolve client IPs. If empty, forwarding headers are ignored
+	TrustedProxies string `yaml:"trusted-proxies" json:"trusted-proxies"`
+	//ForwardedHeader header used by trusted proxies to forward client addresses: "X-Forwarded-For", "Forwarded" or "X-Real-IP". Other forwarding headers are ignored
+	ForwardedHeader string `yaml:"forwarded-header" json:"forwarded-header"`
+	//RateLimitKey how clients are identified by the default rate limit of groups: "ip", "api-key", "principal" or "input:[attribute]". See SetRateLimit
+	RateLimitKey string `yaml:"rate-limit-key" json:"rate-limit-key"`
+	//RateLimit default evaluations per second of each client in each group. If 0, evaluations are not rate limited
+	RateLimit float64 `yaml:"rate-limit" json:"rate-limit"`
+	//RateLimitBurst default burst of evaluations of each client in each group. Defaults to RateLimit
+	RateLimitBurst int `yaml:"rate-limit-burst" json:"rate-limit-burst"`
+	//MaxConcurrentEvaluations default max concurrent evaluations of each group. If 0, concurrency is not limited
+	MaxConcurrentEvaluations int `yaml:"max-concurrent-evaluations" json:"max-concurrent-evaluations"`
+	//MaxBodySize max size of request bodies in bytes. If 0, the size is not limited
+	MaxBodySize int `yaml:"max-body-size" json:"max-body-size"`
+	//MaxInputDepth max nesting depth of inputs. If 0, the depth is not limited
+	MaxInputDepth int `yaml:"max-input-depth" json:"max-input-depth"`
+	//MaxInputKeys max number of attributes of inputs, including nested ones. If 0, the number of keys is not limited
+	MaxInputKeys int `yaml:"max-input-keys" json:"max-input-keys"`
+	//AllowDuplicateKeys accept JSON inputs with repeated keys
+	AllowDuplicateKeys bool `yaml:"allow-duplicate-keys" json:"allow-duplicate-keys"`
+	//JSONUseNumber decode JSON numbers as json.Number to keep the precision of large integers
+	JSONUseNumber bool `yaml:"json-use-number" json:"json-use-number"`
+	//WS enable dummy websocket at /ws
+	WS bool `yaml:"ws" json:"ws"`
+	//Origins comma separated origins allowed by CORS. Patterns with "*" are supported, as in "https://*.example.com". If empty, CORS is disabled
+	Origins string `yaml:"origins" json:"origins"`
+	//AllowMethods comma separated methods allowed by CORS preflight requests
+	AllowMethods string `yaml:"allow-methods" json:"allow-methods"`
+	//AllowHeaders comma separated request headers allowed by CORS preflight requests. "*" allows any header
+	AllowHeaders string `yaml:"allow-headers" json:"allow-headers"`
+	//ExposeHeaders comma separated response headers exposed to scripts of allowed origins
+	ExposeHeaders string `yaml:"expose-headers" json:"expose-headers"`
+	//AllowCredentials allow CORS requests with credentials (cookies or HTTP authentication)
+	AllowCredentials bool `yaml:"allow-credentials" json:"allow-credentials"`
+	//CORSMaxAge seconds browsers may cache CORS preflight responses. If 0, browser defaults are used
+	CORSMaxAge int `yaml:"cors-max-age" json:"cors-max-age"`
+	//CORSRoutes comma separated path templates of routes with CORS enabled
+	CORSRoutes string `yaml:"cors-routes" json:"cors-routes"`
+}
+
+//configField describes how a ServerConfig field is set from flags and environment variables
+type configField struct {
+	name  string
+	usage string
+	value func(c *ServerConfig) interface{}
+}
+
+var configFields = []configField{
+	{"listen-address", "REST API server listen ip address", func(c *ServerConfig) interface{} { return &c.ListenAddress }},
+	{"listen-port", "REST API server listen port", func(c *ServerConfig) interface{} { return &c.ListenPort }},
+	{"grpc-port", "gRPC API server listen port. If 0, the gRPC API is disabled", func(c *ServerConfig) interface{} { return &c.GRPCPort }},
+	{"log-level", "debug, info, warning or error", func(c *ServerConfig) interface{} { return &c.LogLevel }},
+	{"geolite2-db", "Geolite mmdb database file. If not defined, localization info based on IP will be disabled", func(c *ServerConfig) interface{} { return &c.GeoLite2DB }},
+	{"city-state-db", "City->State database file in CSV format 'country-code,city,state'. If defined, input '_ip_state' will be calculated according to '_ip_city'.", func(c *ServerConfig) interface{} { return &c.CityStateDB }},
+	{"rule-state-file", "File used to persist rules enabled/disabled at runtime. If not defined, rule states are kept in memory only", func(c *ServerConfig) interface{} { return &c.RuleStateFile }},
+	{"tls-cert", "TLS certificate file (PEM). If defined along with --tls-key, APIs are served with TLS. Reloaded when changed", func(c *ServerConfig) interface{} { return &c.TLSCert }},
+	{"tls-key", "TLS private key file (PEM)", func(c *ServerConfig) interface{} { return &c.TLSKey }},
+	{"tls-client-ca", "CA bundle file (PEM) used to verify client certificates. If defined, clients must present a valid certificate", func(c *ServerConfig) interface{} { return &c.TLSClientCA }},
+	{"tls-client-optional", "Accept clients without certificates when --tls-client-ca is defined", func(c *ServerConfig) interface{} { return &c.TLSClientOptional }},
+	{"auth-api-keys-file", "YAML or JSON file with API keys in format {\"[api key]\": \"[principal]\"}. Enables API key authentication with header X-API-Key", func(c *ServerConfig) interface{} { return &c.AuthAPIKeysFile }},
+	{"auth-hmac-secrets-file", "YAML or JSON file with HMAC secrets in format {\"[key id]\": \"[secret]\"}. Enables HMAC signed requests authentication", func(c *ServerConfig) interface{} { return &c.AuthHMACSecretsFile }},
+	{"auth-jwks-file", "JWKS file used to verify JWT bearer tokens. Enables JWT authentication", func(c *ServerConfig) interface{} { return &c.AuthJWKSFile }},
+	{"auth-jwt-issuer", "Required 'iss' claim of JWT bearer tokens", func(c *ServerConfig) interface{} { return &c.AuthJWTIssuer }},
+	{"auth-jwt-audience", "Required 'aud' claim of JWT bearer tokens", func(c *ServerConfig) interface{} { return &c.AuthJWTAudience }},
+	{"auth-client-cert", "Authenticate clients by their verified TLS certificates (see --tls-client-ca)", func(c *ServerConfig) interface{} { return &c.AuthClientCert }},
+	{"auth-policy-file", "YAML or JSON file with the authorization policy of principals", func(c *ServerConfig) interface{} { return &c.AuthPolicyFile }},
+	{"trusted-proxies", "Comma separated networks (CIDRs) of proxies whose forwarded header (see --forwarded-header) is trusted. If empty, the IP of the direct requestor is used", func(c *ServerConfig) interface{} { return &c.TrustedProxies }},
+	{"forwarded-header", "Header used by trusted proxies to forward client addresses: X-Forwarded-For, Forwarded or X-Real-IP. Other forwarding headers are ignored", func(c *ServerConfig) interface{} { return &c.ForwardedHeader }},
+	{"rate-limit-key", "How clients are identified by rate limits: ip, api-key, principal or input:[attribute]", func(c *ServerConfig) interface{} { return &c.RateLimitKey }},
+	{"rate-limit", "Evaluations per second allowed for each client in each group. If 0, evaluations are not rate limited", func(c *ServerConfig) interface{} { return &c.RateLimit }},
+	{"rate-limit-burst", "Burst of evaluations allowed for each client in each group. Defaults to --rate-limit", func(c *ServerConfig) interface{} { return &c.RateLimitBurst }},
+	{"max-concurrent-evaluations", "Max concurrent evaluations of each group. If 0, concurrency is not limited", func(c *ServerConfig) interface{} { return &c.MaxConcurrentEvaluations }},
+	{"max-body-size", "Max size of request bodies in bytes. If 0, the size is not limited", func(c *ServerConfig) interface{} { return &c.MaxBodySize }},
+	{"max-input-depth", "Max nesting depth of inputs. If 0, the depth is not limited", func(c *ServerConfig) interface{} { return &c.MaxInputDepth }},
+	{"max-input-keys", "Max number of attributes of inputs, including nested ones. If 0, the number of keys is not limited", func(c *ServerConfig) interface{} { return &c.MaxInputKeys }},
+	{"allow-duplicate-keys", "Accept JSON inputs with repeated keys, keeping the last value", func(c *ServerConfig) interface{} { return &c.AllowDuplicateKeys }},
+	{"json-use-number", "Decode JSON numbers as json.Number to keep the precision of large integers", func(c *ServerConfig) interface{} { return &c.JSONUseNumber }},
+	{"ws", "Enable dummy WS at /ws (useful for detecting ruller restarts)", func(c *ServerConfig) interface{} { return &c.WS }},
+	{"origins", "Comma separated origins allowed by CORS, as in https://*.example.com. If empty, CORS is disabled", func(c *ServerConfig) interface{} { return &c.Origins }},
+	{"allow-methods", "Comma separated methods allowed by CORS", func(c *ServerConfig) interface{} { return &c.AllowMethods }},
+	{"allow-headers", "Comma separated request headers allowed by CORS. * allows any header", func(c *ServerConfig) interface{} { return &c.AllowHeaders }},
+	{"expose-headers", "Comma separated response headers exposed by CORS", func(c *ServerConfig) interface{} { return &c.ExposeHeaders }},
+	{"allow-credentials", "Allow CORS requests with credentials", func(c *ServerConfig) interface{} { return &c.AllowCredentials }},
+	{"cors-max-age", "Seconds browsers may cache CORS preflight responses", func(c *ServerConfig) interface{} { return &c.CORSMaxAge }},
+	{"cors-routes", "Comma separated path templates of routes with CORS enabled", func(c *ServerConfig) interface{} { return &c.CORSRoutes }},
+}
+
+//DefaultServerConfig returns the default server configuration
+func DefaultServerConfig() ServerConfig {
+	limits := DefaultInputLimits()
+	return ServerConfig{
+		ListenAddress:   "0.0.0.0",
+		ListenPort:      3000,
+		LogLevel:        "info",
+		ForwardedHeader: "X-Forwarded-For",
+		RateLimitKey:    "ip",
+		MaxBodySize:     limits.MaxBodySize,
+		MaxInputDepth:   limits.MaxDepth,
+		MaxInputKeys:    limits.MaxKeys,
+		WS:              true,
+		AllowMethods:    "POST, GET, OPTIONS",
+		AllowHeaders:    "Accept, Accept-Encoding, Cache-Control, User-Agent, Accept-Language, Content-Type",
+		ExposeHeaders:   "ETag, Retry-After",
+		CORSRoutes:      strings.Join(DefaultCORSRoutes, ", "),
+	}
+}
+
+//LoadServerConfig builds the server configuration from, in order of precedence, command line args, environment variables, a config file and defaults.
+//Ruller flags are registered in fs (a new flag set is used if nil) and args are parsed with it, so that host programs may keep their own flags.
+//Environment variables are named after flags, as in RULLER_LISTEN_PORT for "--listen-port".
+//The config file (YAML or JSON with flag names as keys) is defined with "--config" or RULLER_CONFIG
+func LoadServerConfig(fs *flag.FlagSet, args []string) (ServerConfig, error) {
+	if fs == nil {
+		fs = flag.NewFlagSet("ruller", flag.ContinueOnError)
+	}
+	defaults := DefaultServerConfig()
+	flagValues := DefaultServerConfig()
+	for _, cf := range configFields {
+		switch v := cf.value(&flagValues).(type) {
+		case *string:
+			fs.StringVar(v, cf.name, *v, cf.usage)
+		case *int:
+			fs.IntVar(v, cf.name, *v, cf.usage)
+		case *bool:
+			fs.BoolVar(v, cf.name, *v, cf.usage)
+		case *float64:
+			fs.Float64Var(v, cf.name, *v, cf.usage)
+		}
+	}
+	configFile := fs.String("config", "", "Config file in YAML or JSON format with flag names as keys")
+	err := fs.Parse(args)
+	if err != nil {
+		return defaults, err
+	}
+
+	cfg := defaults
+	if *configFile == "" {
+		*configFile = os.Getenv("RULLER_CONFIG")
+	}
+	if *configFile != "" {
+		data, err := ioutil.ReadFile(*configFile)
+		if err != nil {
+			return defaults, err
+		}
+		err = yaml.UnmarshalStrict(data, &cfg)
+		if err != nil {
+			return defaults, fmt.Errorf("Invalid config file %s. err=%s", *configFile, err)
+		}
+	}
+
+	for _, cf := range configFields {
+		envName := "RULLER_" + strings.ToUpper(strings.Replace(cf.name, "-", "_", -1))
+		envValue, exists := os.LookupEnv(envName)
+		if !exists {
+			continue
+		}
+		err := setConfigValue(cf.value(&cfg), envValue)
+		if err != nil {
+			return defaults, fmt.Errorf("Invalid value for %s. err=%s", envName, err)
+		}
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		for _, cf := range configFields {
+			if cf.name == f.Name {
+				setConfigValue(cf.value(&cfg), f.Value.String())
+			}
+		}
+	})
+	return cfg, nil
+}
+
+func setConfigValue(field interface{}, value string) error {
+	switch v := field.(type) {
+	case *string:
+		*v = value
+	case *int:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*v = i
+	case *bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*v = b
+	case *float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		*v = f
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package ruller
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadServerConfig(t *testing.T) {
+	cfg, err := LoadServerConfig(nil, []string{})
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultServerConfig(), cfg)
+
+	dir, _ := ioutil.TempDir("", "ruller")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "ruller.yml")
+	ioutil.WriteFile(file, []byte("listen-port: 4000\ngrpc-port: 5000\norigins: http://file\n"), 0644)
+	os.Setenv("RULLER_GRPC_PORT", "6000")
+	os.Setenv("RULLER_WS", "false")
+	defer os.Unsetenv("RULLER_GRPC_PORT")
+	defer os.Unsetenv("RULLER_WS")
+
+	fs := flag.NewFlagSet("host", flag.ContinueOnError)
+	hostFlag := fs.String("host-flag", "", "flag of the host program")
+	cfg, err = LoadServerConfig(fs, []string{"--config", file, "--origins", "http://flag", "--host-flag", "x"})
+	assert.Nil(t, err)
+	assert.Equal(t, "x", *hostFlag)
+	assert.Equal(t, 4000, cfg.ListenPort)
+	assert.Equal(t, 6000, cfg.GRPCPort)
+	assert.Equal(t, false, cfg.WS)
+	assert.Equal(t, "http://flag", cfg.Origins)
+	assert.Equal(t, "0.0.0.0", cfg.ListenAddress)
+
+	ioutil.WriteFile(file, []byte("listen-prot: 4000\n"), 0644)
+	_, err = LoadServerConfig(nil, []string{"--config", file})
+	assert.NotNil(t, err)
+}
+
+func TestStartServerWithConfig(t *testing.T) {
+	Add("server", "rule1", func(ctx Context) (map[string]interface{}, error) {
+		return map[string]interface{}{"a": 1}, nil
+	})
+	lis, _ := net.Listen("tcp", "127.0.0.1:0")
+	port := lis.Addr().(*net.TCPAddr).Port
+	lis.Close()
+
+	cfg := DefaultServerConfig()
+	cfg.ListenAddress = "127.0.0.1"
+	cfg.ListenPort = port
+	cfg.Origins = "http://example.com"
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- StartServerWithConfig(ctx, cfg)
+	}()
+
+	var resp *http.Response
+	var err error
+	req, _ := http.NewRequest("GET", fmt.Sprintf("http://127.0.0.1:%d/rules/server", port), nil)
+	req.Header.Set("Origin", "http://example.com")
+	for i := 0; i < 50; i++ {
+		resp, err = http.DefaultClient.Do(req)
+		if err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.Nil(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "http://example.com", resp.Header.Get("Access-Control-Allow-Origin"))
+	resp.Body.Close()
+
+	cancel()
+	select {
+	case err = <-done:
+		assert.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server didn't stop")
+	}
+}
+
+func TestStartServerWithConfigFailure(t *testing.T) {
+	lis, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer lis.Close()
+	cfg := DefaultServerConfig()
+	cfg.ListenAddress = "127.0.0.1"
+	cfg.ListenPort = lis.Addr().(*net.TCPAddr).Port
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.NotNil(t, StartServerWithConfig(ctx, cfg))
+
+	//the goroutine waiting for ctx to shut down the server must be gone
+	leaked := true
+	for i := 0; i < 50 && leaked; i++ {
+		buf := make([]byte, 1<<20)
+		leaked = strings.Contains(string(buf[:runtime.Stack(buf, true)]), "StartServerWithConfig.func")
+		if leaked {
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	assert.False(t, leaked)
+}
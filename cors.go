@@ -0,0 +1,157 @@
+package ruller
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+//CORSPolicy cross-origin resource sharing policy of the REST API
+type CORSPolicy struct {
+	//AllowedOrigins origins allowed to call the API, as in "https://app.example.com". Patterns with "*" are supported, as in "https://*.example.com". "*" allows any origin.
+	//If empty, CORS is disabled
+	AllowedOrigins []string
+	//AllowedMethods methods allowed in preflight requests
+	AllowedMethods []string
+	//AllowedHeaders request headers allowed in preflight requests. "*" allows the headers requested by the browser
+	AllowedHeaders []string
+	//ExposedHeaders response headers browsers may expose to scripts, as in "ETag"
+	ExposedHeaders []string
+	//AllowCredentials allow requests with cookies or HTTP authentication
+	AllowCredentials bool
+	//MaxAge how long browsers may cache preflight responses. If 0, browser defaults are used
+	MaxAge time.Duration
+	//Routes path templates of the routes with CORS enabled, as in "/rules/{groupName}"
+	Routes []string
+}
+
+var corsPolicy = CORSPolicy{}
+
+//DefaultCORSRoutes routes with CORS enabled by default. Admin routes and metrics are not included
+var DefaultCORSRoutes = []string{"/rules/{groupName}", "/rules/{groupName}/bundle", "/openapi.json", "/info"}
+
+//SetCORSPolicy sets the CORS policy used by Middleware. Preflight requests are only routed to the built-in server routes in policy.Routes when the server starts.
+//Credentials can't be allowed for any origin ("*"), as any site could then read responses with the credentials of its visitors
+func SetCORSPolicy(policy CORSPolicy) error {
+	if policy.AllowCredentials && containsString(policy.AllowedOrigins, "*") {
+		return fmt.Errorf("CORS credentials can't be allowed for any origin ('*'). List the allowed origins instead")
+	}
+	corsPolicy = policy
+	return nil
+}
+
+//Middleware applies the CORS policy (see SetCORSPolicy) to requests of routes with CORS enabled and answers their preflight requests
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policy := corsPolicy
+		if !policy.enabled(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		if preflight {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		origin := r.Header.Get("Origin")
+		if origin != "" && policy.allowsOrigin(origin) {
+			if containsString(policy.AllowedOrigins, "*") {
+				h.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				h.Set("Access-Control-Allow-Origin", origin)
+			}
+			if policy.AllowCredentials && !containsString(policy.AllowedOrigins, "*") {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+			if preflight {
+				h.Set("Access-Control-Allow-Methods", strings.Join(policy.AllowedMethods, ", "))
+				if containsString(policy.AllowedHeaders, "*") {
+					h.Set("Access-Control-Allow-Headers", r.Header.Get("Access-Control-Request-Headers"))
+				} else if len(policy.AllowedHeaders) > 0 {
+					h.Set("Access-Control-Allow-Headers", strings.Join(policy.AllowedHeaders, ", "))
+				}
+				if policy.MaxAge > 0 {
+					h.Set("Access-Control-Max-Age", strconv.Itoa(int(policy.MaxAge.Seconds())))
+				}
+			} else if len(policy.ExposedHeaders) > 0 {
+				h.Set("Access-Control-Expose-Headers", strings.Join(policy.ExposedHeaders, ", "))
+			}
+		} else if origin != "" {
+			logrus.Debugf("Origin %s not allowed by CORS policy", origin)
+		}
+
+		if preflight {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//enabled whatever the policy applies to the route of a request
+func (p CORSPolicy) enabled(r *http.Request) bool {
+	if len(p.AllowedOrigins) == 0 {
+		return false
+	}
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return false
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return false
+	}
+	return containsString(p.Routes, template)
+}
+
+func (p CORSPolicy) allowsOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range p.AllowedOrigins {
+		if matchOrigin(strings.ToLower(allowed), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+//matchOrigin matches an origin against a pattern in which "*" matches any sequence of characters
+func matchOrigin(pattern string, origin string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == origin
+	}
+	if !strings.HasPrefix(origin, parts[0]) {
+		return false
+	}
+	origin = origin[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(origin, part)
+		if i < 0 {
+			return false
+		}
+		origin = origin[i+len(part):]
+	}
+	return strings.HasSuffix(origin, last)
+}
+
+//splitList splits comma separated values, ignoring empty ones
+func splitList(s string) []string {
+	list := make([]string, 0)
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,97 @@
+package ruller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCORS(t *testing.T) {
+	err := SetCORSPolicy(CORSPolicy{AllowedOrigins: []string{"*"}, AllowCredentials: true, Routes: []string{"/rules/{groupName}"}})
+	assert.NotNil(t, err)
+	assert.False(t, corsPolicy.AllowCredentials)
+
+	err = SetCORSPolicy(CORSPolicy{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org"},
+		AllowedMethods:   []string{"POST", "GET"},
+		AllowedHeaders:   []string{"Content-Type"},
+		ExposedHeaders:   []string{"ETag"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+		Routes:           []string{"/rules/{groupName}"},
+	})
+	assert.Nil(t, err)
+	defer SetCORSPolicy(CORSPolicy{})
+
+	called := false
+	handler := func(w http.ResponseWriter, r *http.Request) { called = true }
+	router := mux.NewRouter()
+	router.HandleFunc("/rules/{groupName}", handler).Methods("POST")
+	router.HandleFunc("/admin/overrides/{groupName}", handler).Methods("GET")
+	router.HandleFunc("/rules/{groupName}", func(w http.ResponseWriter, r *http.Request) {}).Methods("OPTIONS")
+	router.Use(Middleware)
+
+	request := func(method string, path string, origin string, preflightMethod string) *httptest.ResponseRecorder {
+		called = false
+		r, _ := http.NewRequest(method, path, nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if preflightMethod != "" {
+			r.Header.Set("Access-Control-Request-Method", preflightMethod)
+			r.Header.Set("Access-Control-Request-Headers", "content-type")
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	//preflight
+	w := request("OPTIONS", "/rules/cors", "https://menu.example.org", "POST")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.False(t, called)
+	assert.Equal(t, "https://menu.example.org", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "POST, GET", w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Content-Type", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"))
+	assert.Equal(t, "", w.Header().Get("Access-Control-Expose-Headers"))
+	assert.Equal(t, []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"}, w.Header()["Vary"])
+
+	w = request("OPTIONS", "/rules/cors", "https://evil.com", "POST")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "", w.Header().Get("Access-Control-Allow-Methods"))
+
+	//simple requests
+	w = request("POST", "/rules/cors", "https://app.example.com", "")
+	assert.True(t, called)
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "ETag", w.Header().Get("Access-Control-Expose-Headers"))
+	assert.Equal(t, "", w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, []string{"Origin"}, w.Header()["Vary"])
+
+	w = request("POST", "/rules/cors", "https://app.example.com.evil.com", "")
+	assert.True(t, called)
+	assert.Equal(t, "", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, []string{"Origin"}, w.Header()["Vary"])
+
+	//routes without CORS
+	w = request("GET", "/admin/overrides/cors", "https://app.example.com", "")
+	assert.True(t, called)
+	assert.Equal(t, "", w.Header().Get("Access-Control-Allow-Origin"))
+	w = request("OPTIONS", "/admin/overrides/cors", "https://app.example.com", "GET")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	//any origin
+	assert.Nil(t, SetCORSPolicy(CORSPolicy{AllowedOrigins: []string{"*"}, AllowedHeaders: []string{"*"}, Routes: []string{"/rules/{groupName}"}}))
+	w = request("OPTIONS", "/rules/cors", "https://any.com", "POST")
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "content-type", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "", w.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "", w.Header().Get("Access-Control-Max-Age"))
+}
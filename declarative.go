@@ -0,0 +1,205 @@
+package ruller
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+//Condition compares an input attribute with a value. Nested attributes may be referenced with dotted paths (ex.: "device.os").
+//Operators: "eq", "ne", "gt", "gte", "lt", "lte", "in" (value is a list), "contains" (attribute is a list or a string), "exists" and "missing"
+type Condition struct {
+	Attribute string      `json:"attribute"`
+	Operator  string      `json:"operator"`
+	Value     interface{} `json:"value,omitempty"`
+}
+
+//DeclarativeRule a rule described as data instead of a Go function. Its output is returned when all conditions match.
+//Unlike Go function rules, declarative rules can be exported in bundles and evaluated embedded in other services (see ExportBundle)
+type DeclarativeRule struct {
+	Name   string `json:"name"`
+	Parent string `json:"parent,omitempty"`
+	//Priority see SetPriority
+	Priority int `json:"priority,omitempty"`
+	//ChildrenStrategy "all" (default) or "first-match". See SetChildrenStrategy
+	ChildrenStrategy string `json:"childrenStrategy,omitempty"`
+	//When all conditions must match for the output to be returned. Rules without conditions always match
+	When   []Condition            `json:"when,omitempty"`
+	Output map[string]interface{} `json:"output"`
+	//DependsOn rules evaluated before this one (see AddDependency). They may be declared after this rule
+	DependsOn []string `json:"dependsOn,omitempty"`
+}
+
+var operators = map[string]func(v interface{}, exists bool, value interface{}) bool{
+	"eq": func(v interface{}, exists bool, value interface{}) bool { return exists && equalValues(v, value) },
+	"ne": func(v interface{}, exists bool, value interface{}) bool { return !exists || !equalValues(v, value) },
+	"gt": func(v interface{}, exists bool, value interface{}) bool {
+		return compareValues(v, value, func(c int) bool { return c > 0 })
+	},
+	"gte": func(v interface{}, exists bool, value interface{}) bool {
+		return compareValues(v, value, func(c int) bool { return c >= 0 })
+	},
+	"lt": func(v interface{}, exists bool, value interface{}) bool {
+		return compareValues(v, value, func(c int) bool { return c < 0 })
+	},
+	"lte": func(v interface{}, exists bool, value interface{}) bool {
+		return compareValues(v, value, func(c int) bool { return c <= 0 })
+	},
+	"exists":  func(v interface{}, exists bool, value interface{}) bool { return exists && v != nil },
+	"missing": func(v interface{}, exists bool, value interface{}) bool { return !exists || v == nil },
+	"in": func(v interface{}, exists bool, value interface{}) bool {
+		if !exists {
+			return false
+		}
+		for _, item := range toArray(value) {
+			if equalValues(v, item) {
+				return true
+			}
+		}
+		return false
+	},
+	"contains": func(v interface{}, exists bool, value interface{}) bool {
+		if !exists {
+			return false
+		}
+		if s, ok := v.(string); ok {
+			vs, ok := value.(string)
+			return ok && strings.Contains(s, vs)
+		}
+		if v == nil || reflect.ValueOf(v).Kind() != reflect.Slice {
+			return false
+		}
+		for _, item := range toArray(v) {
+			if equalValues(item, value) {
+				return true
+			}
+		}
+		return false
+	},
+}
+
+//AddDeclarative adds declarative rules to a group. Parents must be added before their children
+func AddDeclarative(groupName string, rules ...DeclarativeRule) error {
+	for _, drule := range rules {
+		err := addDeclarative(groupName, drule)
+		if err != nil {
+			return err
+		}
+	}
+	return addDeclarativeDependencies(groupName, rules)
+}
+
+//addDeclarativeDependencies adds the dependencies of declarative rules once all of them were added
+func addDeclarativeDependencies(groupName string, rules []DeclarativeRule) error {
+	for _, drule := range rules {
+		for _, dependencyName := range drule.DependsOn {
+			err := addDependency(groupName, drule.Name, dependencyName)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func addDeclarative(groupName string, drule DeclarativeRule) error {
+	for _, cond := range drule.When {
+		if _, exists := operators[cond.Operator]; !exists {
+			return fmt.Errorf("Rule '%s' has an invalid operator '%s' for attribute '%s'", drule.Name, cond.Operator, cond.Attribute)
+		}
+	}
+	childrenStrategy, err := parseStrategy(drule.ChildrenStrategy)
+	if err != nil {
+		return err
+	}
+
+	err = AddChild(groupName, drule.Name, drule.Parent, func(ctx Context) (map[string]interface{}, error) {
+		if !drule.matches(ctx.Input) {
+			return nil, nil
+		}
+		return copyMap(drule.Output), nil
+	})
+	if err != nil {
+		return err
+	}
+	rinfo := rulesMap[groupName][drule.Name]
+	rinfo.declaration = &drule
+	if drule.Priority != 0 {
+		err = setPriority(groupName, drule.Name, drule.Priority)
+		if err != nil {
+			return err
+		}
+	}
+	rinfo.childrenStrategy = childrenStrategy
+	return nil
+}
+
+//matches true if all conditions match the input
+func (r *DeclarativeRule) matches(input map[string]interface{}) bool {
+	for _, cond := range r.When {
+		v, exists := getPath(input, cond.Attribute)
+		if !operators[cond.Operator](v, exists, cond.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+func getPath(m map[string]interface{}, path string) (interface{}, bool) {
+	parts := strings.Split(path, ".")
+	var current interface{} = m
+	for _, part := range parts {
+		cm, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current, ok = cm[part]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func equalValues(a interface{}, b interface{}) bool {
+	af, ok1 := toFloat64(a)
+	bf, ok2 := toFloat64(b)
+	if ok1 && ok2 {
+		return af == bf
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func compareValues(a interface{}, b interface{}, test func(int) bool) bool {
+	af, ok1 := toFloat64(a)
+	bf, ok2 := toFloat64(b)
+	if ok1 && ok2 {
+		return test(compareFloats(af, bf))
+	}
+	as, ok1 := a.(string)
+	bs, ok2 := b.(string)
+	if ok1 && ok2 {
+		return test(strings.Compare(as, bs))
+	}
+	return false
+}
+
+func compareFloats(a float64, b float64) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+func parseStrategy(name string) (EvaluationStrategy, error) {
+	switch name {
+	case "", EvaluateAll.String():
+		return EvaluateAll, nil
+	case EvaluateFirstMatch.String():
+		return EvaluateFirstMatch, nil
+	}
+	return EvaluateAll, fmt.Errorf("Invalid evaluation strategy '%s'", name)
+}
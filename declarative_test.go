@@ -0,0 +1,140 @@
+package ruller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeclarativeRules(t *testing.T) {
+	err := AddDeclarative("declarative",
+		DeclarativeRule{Name: "adult", When: []Condition{{Attribute: "age", Operator: "gte", Value: 18}}, Output: map[string]interface{}{"adult": true}},
+		DeclarativeRule{Name: "region", When: []Condition{{Attribute: "device.country", Operator: "in", Value: []interface{}{"BR", "PT"}}}, Output: map[string]interface{}{"lang": "pt"}},
+		DeclarativeRule{Name: "beta", When: []Condition{{Attribute: "tags", Operator: "contains", Value: "beta"}, {Attribute: "blocked", Operator: "missing"}}, Output: map[string]interface{}{"beta": true}},
+	)
+	assert.Nil(t, err)
+	err = AddDeclarative("declarative", DeclarativeRule{Name: "invalid", When: []Condition{{Attribute: "age", Operator: "like"}}})
+	assert.NotNil(t, err)
+
+	options := ProcessOptions{FlattenOutput: true}
+	output, err := Process("declarative", map[string]interface{}{"age": 20.0, "device": map[string]interface{}{"country": "BR"}, "tags": []interface{}{"beta"}}, options)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"adult": true, "lang": "pt", "beta": true}, output)
+
+	output, err = Process("declarative", map[string]interface{}{"age": 12.0, "tags": []interface{}{"beta"}, "blocked": true}, options)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{}, output)
+}
+
+func TestBundle(t *testing.T) {
+	AddRequiredInput("bundle", "age", Float64)
+	SetGroupStrategy("bundle", EvaluateFirstMatch)
+	err := AddDeclarative("bundle",
+		DeclarativeRule{Name: "kid", When: []Condition{{Attribute: "age", Operator: "lt", Value: 13}}, Output: map[string]interface{}{"category": "kid"}},
+		DeclarativeRule{Name: "other", Output: map[string]interface{}{"category": "other"}},
+	)
+	assert.Nil(t, err)
+	Add("bundle", "go", func(ctx Context) (map[string]interface{}, error) {
+		return map[string]interface{}{"go": true}, nil
+	})
+	AddDeclarative("bundle", DeclarativeRule{Name: "go.child", Parent: "go", Output: map[string]interface{}{"child": true}})
+	SetPriority("bundle", "other", -1)
+	assert.Nil(t, AddDependency("bundle", "other", "kid"))
+	SetDefaultTreeOutput("bundle", true)
+	SetKeyMergeStrategy("bundle", "score", SumValues)
+	DeclareOutput("bundle", "category", String, "kid", "other")
+	SetOutputValidation("bundle", ValidationReject)
+	assert.Nil(t, SetOverride("bundle", "userId", "1", map[string]interface{}{"category": "kid"}, "test"))
+
+	bundle, err := ExportBundle("bundle")
+	assert.Nil(t, err)
+	assert.NotEmpty(t, bundle.Version)
+	assert.Equal(t, "first-match", bundle.Strategy)
+	assert.Equal(t, map[string]string{"age": "numeric"}, bundle.RequiredInputs)
+	assert.Equal(t, []string{"go", "go.child"}, bundle.ServerOnly)
+	assert.Equal(t, 2, len(bundle.Rules))
+	assert.Equal(t, "kid", bundle.Rules[0].Name)
+	assert.Equal(t, -1, bundle.Rules[1].Priority)
+	assert.Equal(t, []string{"kid"}, bundle.Rules[1].DependsOn)
+	assert.True(t, bundle.TreeOutput)
+	assert.Equal(t, map[string]string{"score": "sum"}, bundle.KeyMergeStrategies)
+	assert.Equal(t, "reject", bundle.OutputValidation)
+	assert.Equal(t, OutputDescription{Type: "string", Values: []string{"kid", "other"}}, bundle.Outputs["category"])
+	assert.Equal(t, map[string]interface{}{"category": "kid"}, bundle.Overrides["userId"]["1"])
+
+	desc, _ := DescribeGroup("bundle")
+	assert.True(t, desc.Rules[1].ServerOnly)
+	assert.False(t, desc.Rules[0].ServerOnly)
+
+	SetRuleEnabled("bundle", "kid", false, "test")
+	disabled, _ := ExportBundle("bundle")
+	assert.NotEqual(t, bundle.Version, disabled.Version)
+	SetRuleEnabled("bundle", "kid", true, "test")
+
+	bundle.Group = "bundle-loaded"
+	err = LoadBundle(bundle)
+	assert.Nil(t, err)
+	assert.Equal(t, bundle.Version, BundleVersion("bundle-loaded"))
+	assert.Equal(t, SumValues, keyMergeStrategy["bundle-loaded"]["score"])
+	assert.Equal(t, ValidationReject, outputValidation["bundle-loaded"])
+	assert.Equal(t, "kid", rulesMap["bundle-loaded"]["other"].dependencies[0].name)
+	loaded, err := ExportBundle("bundle-loaded")
+	assert.Nil(t, err)
+	loaded.Group, loaded.Version, loaded.ServerOnly = bundle.Group, bundle.Version, bundle.ServerOnly
+	assert.Equal(t, bundle, loaded, "settings must survive export and load")
+	output, err := Evaluate("bundle-loaded", map[string]interface{}{"age": 30.0, "userId": 1.0, "_flatten": true})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"category": "kid"}, output)
+	output, err = Evaluate("bundle-loaded", map[string]interface{}{"age": 10.0, "_flatten": true})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"category": "kid"}, output)
+	output, err = Evaluate("bundle-loaded", map[string]interface{}{"age": 30.0, "_flatten": true})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"category": "other"}, output)
+	_, err = Evaluate("bundle-loaded", map[string]interface{}{"_flatten": true})
+	assert.NotNil(t, err)
+
+	bundle.Rules = bundle.Rules[1:]
+	bundle.Rules[0].DependsOn = nil
+	err = LoadBundle(bundle)
+	assert.Nil(t, err)
+	output, err = Evaluate("bundle-loaded", map[string]interface{}{"age": 10.0, "_flatten": true})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"category": "other"}, output)
+
+	//failed loads of new groups don't register them
+	invalid := &Bundle{Group: "bundle-invalid", Version: "1", Rules: []DeclarativeRule{{Name: "r1", Parent: "missing"}}}
+	assert.NotNil(t, LoadBundle(invalid))
+	defer delete(bundleErrors, "bundle-invalid")
+	_, err = Process("bundle-invalid", map[string]interface{}{}, ProcessOptions{})
+	assert.NotNil(t, err)
+	_, exists := groupRules["bundle-invalid"]
+	assert.False(t, exists)
+	assert.Equal(t, 0.0, testutil.ToFloat64(groupRuleCount.WithLabelValues("bundle-invalid")))
+
+	invalid.Group = "bundle-loaded"
+	invalid.Rules = []DeclarativeRule{{Name: "r0"}, {Name: "r1", Parent: "missing"}}
+	assert.NotNil(t, LoadBundle(invalid))
+	defer delete(bundleErrors, "bundle-loaded")
+	assert.Equal(t, 1.0, testutil.ToFloat64(groupRuleCount.WithLabelValues("bundle-loaded")))
+
+	//groups with custom merge strategies can't be exported
+	Add("bundle-custom", "rule1", func(ctx Context) (map[string]interface{}, error) { return nil, nil })
+	SetMergeStrategy("bundle-custom", MergeStrategyFunc(func(key string, existing interface{}, incoming interface{}) (interface{}, error) {
+		return existing, nil
+	}))
+	_, err = ExportBundle("bundle-custom")
+	assert.NotNil(t, err)
+	router := mux.NewRouter()
+	router.HandleFunc("/rules/{groupName}/bundle", HandleExportBundle)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/rules/bundle-custom/bundle", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/rules/bundle-unknown/bundle", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,516 @@
+package ruller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v4"
+	"github.com/vmihailenco/msgpack/v4/codes"
+	"gopkg.in/yaml.v2"
+)
+
+//Decoder parses request bodies of a specific format into the input map used for rules processing
+type Decoder interface {
+	Decode(body []byte) (map[string]interface{}, error)
+}
+
+//DecoderFunc adapter to use ordinary functions as decoders
+type DecoderFunc func(body []byte) (map[string]interface{}, error)
+
+//Decode calls f(body)
+func (f DecoderFunc) Decode(body []byte) (map[string]interface{}, error) {
+	return f(body)
+}
+
+var (
+	//JSONDecoder parses JSON bodies enforcing the nesting depth, number of keys and duplicate keys limits (see SetInputLimits)
+	JSONDecoder Decoder = DecoderFunc(func(body []byte) (map[string]interface{}, error) {
+		return decodeJSON(body, inputLimits)
+	})
+	//FormDecoder parses url encoded forms. Attributes with more than one value become arrays
+	FormDecoder Decoder = DecoderFunc(func(body []byte) (map[string]interface{}, error) {
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, err
+		}
+		input := make(map[string]interface{})
+		for k, vs := range values {
+			if len(vs) == 1 {
+				input[k] = vs[0]
+				continue
+			}
+			arr := make([]interface{}, len(vs))
+			for i, v := range vs {
+				arr[i] = v
+			}
+			input[k] = arr
+		}
+		return input, nil
+	})
+	//MessagePackDecoder parses MessagePack bodies enforcing the nesting depth and number of keys limits while decoding. Numbers are converted to float64, as with JSON
+	MessagePackDecoder Decoder = DecoderFunc(func(body []byte) (map[string]interface{}, error) {
+		dec := msgpack.NewDecoder(bytes.NewReader(body))
+		dec.UseDecodeInterfaceLoose(true)
+		p := msgpackParser{dec: dec, limits: inputLimits}
+		v, err := p.value(1)
+		if err != nil {
+			return nil, err
+		}
+		return toInputMap(v)
+	})
+	//YAMLDecoder parses YAML bodies enforcing the nesting depth and number of keys limits while decoding, so that aliases can't expand into large inputs.
+	//Numbers are converted to float64, as with JSON
+	YAMLDecoder Decoder = DecoderFunc(func(body []byte) (map[string]interface{}, error) {
+		root := yamlNode{p: &yamlParser{limits: inputLimits}, depth: 1}
+		err := yaml.Unmarshal(body, &root)
+		if err != nil {
+			return nil, err
+		}
+		return toInputMap(root.value)
+	})
+)
+
+var decoders = map[string]Decoder{
+	"application/json":                  JSONDecoder,
+	"application/x-www-form-urlencoded": FormDecoder,
+	"application/msgpack":               MessagePackDecoder,
+	"application/x-msgpack":             MessagePackDecoder,
+	"application/yaml":                  YAMLDecoder,
+	"application/x-yaml":                YAMLDecoder,
+	"text/yaml":                         YAMLDecoder,
+}
+
+//specialInputs types of the special attributes that control processing, used for coercion
+var specialInputs = map[string]InputType{
+	"_flatten":    Bool,
+	"_keepFirst":  Bool,
+	"_info":       Bool,
+	"_explain":    Bool,
+	"_treeOutput": Bool,
+}
+
+//InputLimits limits applied to request inputs to protect the server from large or malicious bodies
+type InputLimits struct {
+	//MaxBodySize max size of request bodies in bytes. Larger bodies get 413. If 0, the size is not limited
+	MaxBodySize int
+	//MaxDepth max nesting depth of objects and arrays. If 0, the depth is not limited
+	MaxDepth int
+	//MaxKeys max number of attributes, including the ones of nested objects. If 0, the number of keys is not limited
+	MaxKeys int
+	//AllowDuplicateKeys accept JSON objects with repeated keys, keeping the last value. By default they are rejected
+	AllowDuplicateKeys bool
+	//UseNumber decode JSON numbers as json.Number instead of float64 so that large integers keep their precision
+	UseNumber bool
+}
+
+var inputLimits = DefaultInputLimits()
+
+//DefaultInputLimits 1MB bodies, 32 levels of nesting and 10000 keys
+func DefaultInputLimits() InputLimits {
+	return InputLimits{
+		MaxBodySize: 1 << 20,
+		MaxDepth:    32,
+		MaxKeys:     10000,
+	}
+}
+
+//SetInputLimits sets the limits applied to request inputs of all APIs
+func SetInputLimits(limits InputLimits) {
+	inputLimits = limits
+}
+
+//RegisterDecoder registers a decoder for request bodies with a Content-Type. Existing registrations are replaced
+func RegisterDecoder(mediaType string, decoder Decoder) {
+	decoders[strings.ToLower(mediaType)] = decoder
+}
+
+//decoderFor returns the decoder for a Content-Type header. JSON is used when the header is not present
+func decoderFor(contentType string) (string, Decoder, error) {
+	if strings.TrimSpace(contentType) == "" {
+		return "application/json", JSONDecoder, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", nil, fmt.Errorf("Invalid Content-Type %s", contentType)
+	}
+	decoder, exists := decoders[mediaType]
+	if !exists {
+		return "", nil, fmt.Errorf("Unsupported Content-Type %s", mediaType)
+	}
+	return mediaType, decoder, nil
+}
+
+//readBody reads a request body up to the max body size. Returns errBodyTooLarge if the body is larger
+func readBody(body io.Reader, contentLength int64) ([]byte, error) {
+	max := inputLimits.MaxBodySize
+	if max <= 0 {
+		return ioutil.ReadAll(body)
+	}
+	if contentLength > int64(max) {
+		return nil, errBodyTooLarge
+	}
+	bodyBytes, err := ioutil.ReadAll(io.LimitReader(body, int64(max)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(bodyBytes) > max {
+		return nil, errBodyTooLarge
+	}
+	return bodyBytes, nil
+}
+
+var errBodyTooLarge = fmt.Errorf("Request body too large")
+
+//jsonParser builds the input map from JSON tokens so that limits are enforced while parsing, before large structures are allocated
+type jsonParser struct {
+	dec    *json.Decoder
+	limits InputLimits
+	keys   int
+}
+
+func decodeJSON(body []byte, limits InputLimits) (map[string]interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if limits.UseNumber {
+		dec.UseNumber()
+	}
+	p := jsonParser{dec: dec, limits: limits}
+	v, err := p.value(1)
+	if err != nil {
+		return nil, err
+	}
+	_, err = dec.Token()
+	if err != io.EOF {
+		return nil, fmt.Errorf("Unexpected data after JSON input")
+	}
+	if v == nil {
+		return make(map[string]interface{}), nil
+	}
+	input, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Input must be a map of attributes")
+	}
+	return input, nil
+}
+
+func (p *jsonParser) value(depth int) (interface{}, error) {
+	t, err := p.dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	delim, ok := t.(json.Delim)
+	if !ok {
+		return t, nil
+	}
+	if p.limits.MaxDepth > 0 && depth > p.limits.MaxDepth {
+		return nil, fmt.Errorf("Input nesting depth exceeds %d", p.limits.MaxDepth)
+	}
+
+	if delim == '[' {
+		arr := make([]interface{}, 0)
+		for p.dec.More() {
+			v, err := p.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		_, err = p.dec.Token()
+		return arr, err
+	}
+
+	m := make(map[string]interface{})
+	for p.dec.More() {
+		t, err := p.dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key := t.(string)
+		p.keys++
+		if p.limits.MaxKeys > 0 && p.keys > p.limits.MaxKeys {
+			return nil, fmt.Errorf("Input has more than %d keys", p.limits.MaxKeys)
+		}
+		if _, exists := m[key]; exists && !p.limits.AllowDuplicateKeys {
+			return nil, fmt.Errorf("Duplicate key '%s'", key)
+		}
+		v, err := p.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+	_, err = p.dec.Token()
+	return m, err
+}
+
+//msgpackParser builds the input map from MessagePack so that limits are enforced while decoding, as jsonParser does
+type msgpackParser struct {
+	dec    *msgpack.Decoder
+	limits InputLimits
+	keys   int
+}
+
+func (p *msgpackParser) value(depth int) (interface{}, error) {
+	c, err := p.dec.PeekCode()
+	if err != nil {
+		return nil, err
+	}
+	isMap := codes.IsFixedMap(c) || c == codes.Map16 || c == codes.Map32
+	isArray := codes.IsFixedArray(c) || c == codes.Array16 || c == codes.Array32
+	if !isMap && !isArray {
+		return p.dec.DecodeInterfaceLoose()
+	}
+	if p.limits.MaxDepth > 0 && depth > p.limits.MaxDepth {
+		return nil, fmt.Errorf("Input nesting depth exceeds %d", p.limits.MaxDepth)
+	}
+
+	if isArray {
+		n, err := p.dec.DecodeArrayLen()
+		if err != nil {
+			return nil, err
+		}
+		//lengths come from the body, so nothing is allocated upfront
+		arr := make([]interface{}, 0)
+		for i := 0; i < n; i++ {
+			v, err := p.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		return arr, nil
+	}
+
+	n, err := p.dec.DecodeMapLen()
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]interface{})
+	for i := 0; i < n; i++ {
+		key, err := p.dec.DecodeString()
+		if err != nil {
+			return nil, err
+		}
+		p.keys++
+		if p.limits.MaxKeys > 0 && p.keys > p.limits.MaxKeys {
+			return nil, fmt.Errorf("Input has more than %d keys", p.limits.MaxKeys)
+		}
+		if _, exists := m[key]; exists && !p.limits.AllowDuplicateKeys {
+			return nil, fmt.Errorf("Duplicate key '%s'", key)
+		}
+		v, err := p.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+	return m, nil
+}
+
+//yamlParser builds the input map from YAML so that limits are enforced while decoding. Aliases are decoded again wherever they are used,
+//so the attributes they expand to count against the limits
+type yamlParser struct {
+	limits InputLimits
+	keys   int
+}
+
+//yamlNode decodes a YAML node with its parser. Nodes without a parser are the children of a node being decoded:
+//they only keep their unmarshal function, called by the parent while it is still decoding, so that yaml detects anchors containing themselves
+type yamlNode struct {
+	p         *yamlParser
+	depth     int
+	value     interface{}
+	unmarshal func(interface{}) error
+}
+
+//UnmarshalYAML implements yaml.Unmarshaler
+func (n *yamlNode) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if n.p == nil {
+		n.unmarshal = unmarshal
+		return nil
+	}
+	v, err := n.p.value(unmarshal, n.depth)
+	if err != nil {
+		return err
+	}
+	n.value = v
+	return nil
+}
+
+func (p *yamlParser) value(unmarshal func(interface{}) error, depth int) (interface{}, error) {
+	//children are only decoded into yamlNode, so nothing is expanded before the limits are checked
+	var seq []yamlNode
+	if err := unmarshal(&seq); err == nil && seq != nil {
+		if p.limits.MaxDepth > 0 && depth > p.limits.MaxDepth {
+			return nil, fmt.Errorf("Input nesting depth exceeds %d", p.limits.MaxDepth)
+		}
+		arr := make([]interface{}, 0, len(seq))
+		for _, child := range seq {
+			v, err := p.value(child.unmarshal, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		return arr, nil
+	}
+
+	var mapping map[string]yamlNode
+	mappingErr := unmarshal(&mapping)
+	if mappingErr == nil && mapping == nil {
+		return nil, nil
+	}
+	if mappingErr == nil {
+		if p.limits.MaxDepth > 0 && depth > p.limits.MaxDepth {
+			return nil, fmt.Errorf("Input nesting depth exceeds %d", p.limits.MaxDepth)
+		}
+		p.keys += len(mapping)
+		if p.limits.MaxKeys > 0 && p.keys > p.limits.MaxKeys {
+			return nil, fmt.Errorf("Input has more than %d keys", p.limits.MaxKeys)
+		}
+		m := make(map[string]interface{}, len(mapping))
+		for key, child := range mapping {
+			v, err := p.value(child.unmarshal, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = v
+		}
+		return m, nil
+	}
+
+	//only scalars can be decoded as strings. mappings with keys that aren't scalars are rejected
+	var s string
+	if unmarshal(&s) != nil {
+		return nil, mappingErr
+	}
+	var v interface{}
+	err := unmarshal(&v)
+	return v, err
+}
+
+//checkInputLimits checks the nesting depth and number of keys of inputs decoded by other means than JSONDecoder, such as gRPC and other formats
+func checkInputLimits(input map[string]interface{}) error {
+	keys := 0
+	var check func(v interface{}, depth int) error
+	check = func(v interface{}, depth int) error {
+		switch value := v.(type) {
+		case map[string]interface{}:
+			keys += len(value)
+			for _, mv := range value {
+				if err := check(mv, depth+1); err != nil {
+					return err
+				}
+			}
+		case []interface{}:
+			for _, av := range value {
+				if err := check(av, depth+1); err != nil {
+					return err
+				}
+			}
+		default:
+			return nil
+		}
+		if inputLimits.MaxDepth > 0 && depth > inputLimits.MaxDepth {
+			return fmt.Errorf("Input nesting depth exceeds %d", inputLimits.MaxDepth)
+		}
+		if inputLimits.MaxKeys > 0 && keys > inputLimits.MaxKeys {
+			return fmt.Errorf("Input has more than %d keys", inputLimits.MaxKeys)
+		}
+		return nil
+	}
+	return check(input, 1)
+}
+
+//coerceInput converts input values to the types declared with AddRequiredInput when possible. Used for formats that don't carry types, such as forms
+func coerceInput(groupName string, input map[string]interface{}) {
+	for k, it := range specialInputs {
+		coerceValue(input, k, it)
+	}
+	for k, it := range requiredInputNames[groupName] {
+		coerceValue(input, k, it)
+	}
+}
+
+func coerceValue(input map[string]interface{}, k string, it InputType) {
+	v, exists := input[k]
+	if !exists {
+		return
+	}
+	if it == Array {
+		if _, ok := v.([]interface{}); !ok {
+			input[k] = []interface{}{v}
+		}
+		return
+	}
+	s, ok := v.(string)
+	if !ok {
+		return
+	}
+	switch it {
+	case Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err == nil {
+			input[k] = f
+		}
+	case Bool:
+		b, err := strconv.ParseBool(s)
+		if err == nil {
+			input[k] = b
+		}
+	}
+}
+
+//toInputMap normalizes decoded values to the same types produced by encoding/json
+func toInputMap(v interface{}) (map[string]interface{}, error) {
+	m, ok := normalizeValue(v).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Input must be a map of attributes")
+	}
+	return m, nil
+}
+
+func normalizeValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, mv := range value {
+			m[fmt.Sprintf("%v", k)] = normalizeValue(mv)
+		}
+		return m
+	case map[string]interface{}:
+		for k, mv := range value {
+			value[k] = normalizeValue(mv)
+		}
+		return value
+	case []interface{}:
+		for i, av := range value {
+			value[i] = normalizeValue(av)
+		}
+		return value
+	case uint64:
+		return float64(value)
+	case uint:
+		return float64(value)
+	}
+	if f, ok := toFloat64(v); ok {
+		return f
+	}
+	return v
+}
+
+func sortedDecoderMediaTypes() []string {
+	mediaTypes := make([]string, 0, len(decoders))
+	for mediaType := range decoders {
+		mediaTypes = append(mediaTypes, mediaType)
+	}
+	sort.Strings(mediaTypes)
+	return mediaTypes
+}
@@ -0,0 +1,131 @@
+package ruller
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+func TestRequestDecoding(t *testing.T) {
+	SetDefaultTreeOutput("decoding", true)
+	Add("decoding", "rule1", func(ctx Context) (map[string]interface{}, error) {
+		return map[string]interface{}{"age": ctx.Input["age"], "children": ctx.Input["children"], "tags": ctx.Input["tags"]}, nil
+	})
+	AddRequiredInput("decoding", "age", Float64)
+	AddRequiredInput("decoding", "children", Bool)
+
+	evaluate := func(contentType string, body []byte) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("POST", "/rules/decoding", bytes.NewBuffer(body))
+		r.Header.Set("Content-Type", contentType)
+		r = mux.SetURLVars(r, map[string]string{"groupName": "decoding"})
+		w := httptest.NewRecorder()
+		HandleRuleGroup(w, r)
+		return w
+	}
+
+	w := evaluate("application/x-www-form-urlencoded", []byte("age=22&children=true&tags=a&tags=b&_flatten=true"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"age":22,"children":true,"tags":["a","b"]}`, w.Body.String())
+
+	w = evaluate("application/yaml", []byte("age: 30\nchildren: false\n_flatten: true\ntags:\n  - x\n"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"age":30,"children":false,"tags":["x"]}`, w.Body.String())
+
+	body, _ := msgpack.Marshal(map[string]interface{}{"age": 40, "children": true, "_flatten": true})
+	w = evaluate("application/msgpack", body)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"age":40,"children":true,"tags":null}`, w.Body.String())
+
+	w = evaluate("application/x-www-form-urlencoded", []byte("age=old&children=true"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = evaluate("application/xml", []byte("<a/>"))
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+}
+
+func TestInputLimits(t *testing.T) {
+	SetDefaultTreeOutput("inputlimits", true)
+	Add("inputlimits", "rule1", func(ctx Context) (map[string]interface{}, error) {
+		return map[string]interface{}{"id": ctx.Input["id"]}, nil
+	})
+	AddRequiredInput("inputlimits", "id", Float64)
+	SetInputLimits(InputLimits{MaxBodySize: 100, MaxDepth: 3, MaxKeys: 5})
+	defer SetInputLimits(DefaultInputLimits())
+
+	evaluate := func(contentType string, body []byte) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("POST", "/rules/inputlimits", bytes.NewBuffer(body))
+		r.Header.Set("Content-Type", contentType)
+		r = mux.SetURLVars(r, map[string]string{"groupName": "inputlimits"})
+		w := httptest.NewRecorder()
+		HandleRuleGroup(w, r)
+		return w
+	}
+
+	w := evaluate("application/json", []byte(`{"id":1,"a":{"b":{}}}`))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = evaluate("application/json", bytes.Repeat([]byte(" "), 101))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	w = evaluate("application/json", []byte(`{"id":1,"a":{"b":{"c":{}}}}`))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "depth")
+
+	w = evaluate("application/json", []byte(`{"id":1,"a":[[[1]]]}`))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = evaluate("application/json", []byte(`{"id":1,"a":1,"b":1,"c":1,"d":1,"e":1}`))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "keys")
+
+	w = evaluate("application/json", []byte(`{"id":1,"id":2}`))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "Duplicate key 'id'")
+
+	w = evaluate("application/json", []byte(`{"id":1} {}`))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = evaluate("application/json", []byte(`{"id":`))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	//limits also apply to other formats
+	body, _ := msgpack.Marshal(map[string]interface{}{"id": 1, "a": map[string]interface{}{"b": map[string]interface{}{"c": map[string]interface{}{}}}})
+	w = evaluate("application/msgpack", body)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "depth")
+
+	w = evaluate("application/yaml", []byte("id: 1\na:\n  b:\n    c:\n      d: 1\n"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "depth")
+
+	w = evaluate("application/yaml", []byte("id: 1\na: [[[1]]]\n"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	//attributes expanded by aliases count against the limits
+	w = evaluate("application/yaml", []byte("id: 1\nx: &x {a: 1, b: 1}\ny: [*x, *x]\n"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "keys")
+
+	w = evaluate("application/yaml", []byte("id: 1\nx: &x [1, 2]\ny: *x\n"))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	SetInputLimits(InputLimits{MaxKeys: 1000})
+	bomb := "a: &a {x: 1, y: 1, z: 1}\n"
+	for i := 'b'; i <= 'j'; i++ {
+		bomb += fmt.Sprintf("%c: &%c [*%c, *%c, *%c, *%c, *%c, *%c, *%c, *%c, *%c]\n", i, i, i-1, i-1, i-1, i-1, i-1, i-1, i-1, i-1, i-1)
+	}
+	w = evaluate("application/yaml", []byte(bomb))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "keys")
+
+	SetInputLimits(InputLimits{AllowDuplicateKeys: true, UseNumber: true})
+	w = evaluate("application/json", []byte(`{"id":1,"id":9007199254740993,"_flatten":true}`))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"id":9007199254740993}`, w.Body.String())
+}
@@ -0,0 +1,59 @@
+package ruller
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+//AddDependency declares that a rule depends on the output of another rule of the same group.
+//Dependencies are evaluated before the rule and their outputs are passed in Context.Dependencies. Each rule is evaluated at most once per processing,
+//so a shared computation may be used by many rules. Returns an error if any of the rules doesn't exist or if the dependency would create a cycle
+func AddDependency(groupName string, ruleName string, dependencyRuleName string) error {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	return addDependency(groupName, ruleName, dependencyRuleName)
+}
+
+func addDependency(groupName string, ruleName string, dependencyRuleName string) error {
+	logrus.Debugf("Adding dependency '%s' to rule '%s' of group '%s'", dependencyRuleName, ruleName, groupName)
+	rinfo, exists := rulesMap[groupName][ruleName]
+	if !exists {
+		return fmt.Errorf("Rule '%s' not found in group '%s'", ruleName, groupName)
+	}
+	dinfo, exists := rulesMap[groupName][dependencyRuleName]
+	if !exists {
+		return fmt.Errorf("Dependency rule '%s' not found in group '%s'", dependencyRuleName, groupName)
+	}
+	for _, d := range rinfo.dependencies {
+		if d == dinfo {
+			return nil
+		}
+	}
+	if dinfo == rinfo || evaluatedBefore(rinfo, dinfo, make(map[*ruleInfo]bool)) {
+		return fmt.Errorf("Dependency from rule '%s' to '%s' would create a cycle in group '%s'", ruleName, dependencyRuleName, groupName)
+	}
+
+	rinfo.dependencies = append(rinfo.dependencies, dinfo)
+	dinfo.dependent = true
+	return nil
+}
+
+//evaluatedBefore returns true if 'target' must be evaluated before 'from' can be evaluated (through children or dependencies)
+func evaluatedBefore(target *ruleInfo, from *ruleInfo, visited map[*ruleInfo]bool) bool {
+	if visited[from] {
+		return false
+	}
+	visited[from] = true
+	for _, next := range from.children {
+		if next == target || evaluatedBefore(target, next, visited) {
+			return true
+		}
+	}
+	for _, next := range from.dependencies {
+		if next == target || evaluatedBefore(target, next, visited) {
+			return true
+		}
+	}
+	return false
+}
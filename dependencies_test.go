@@ -0,0 +1,33 @@
+package ruller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRuleDependencies(t *testing.T) {
+	premiumCalls := 0
+	Add("deps", "premium", func(ctx Context) (map[string]interface{}, error) {
+		premiumCalls++
+		return map[string]interface{}{"premium": ctx.Input["plan"] == "gold"}, nil
+	})
+	Add("deps", "banner", func(ctx Context) (map[string]interface{}, error) {
+		return map[string]interface{}{"showBanner": ctx.Dependencies["premium"]["premium"] != true}, nil
+	})
+	AddChild("deps", "menu", "banner", func(ctx Context) (map[string]interface{}, error) {
+		return map[string]interface{}{"fullMenu": ctx.Dependencies["premium"]["premium"]}, nil
+	})
+
+	assert.Nil(t, AddDependency("deps", "banner", "premium"))
+	assert.Nil(t, AddDependency("deps", "menu", "premium"))
+	assert.NotNil(t, AddDependency("deps", "premium", "menu"))
+	assert.NotNil(t, AddDependency("deps", "menu", "banner"))
+	assert.NotNil(t, AddDependency("deps", "banner", "banner"))
+	assert.NotNil(t, AddDependency("deps", "banner", "unknown"))
+
+	output, err := Process("deps", map[string]interface{}{"plan": "gold"}, ProcessOptions{FlattenOutput: true, MergeKeepFirst: true})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"premium": true, "showBanner": false, "fullMenu": true}, output)
+	assert.Equal(t, 1, premiumCalls)
+}
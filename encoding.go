@@ -0,0 +1,188 @@
+package ruller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v4"
+	"gopkg.in/yaml.v2"
+)
+
+//Encoder renders the output of rules in a specific format
+type Encoder interface {
+	//ContentType value of the Content-Type header of responses
+	ContentType() string
+	//Encode renders the output
+	Encode(output map[string]interface{}) ([]byte, error)
+}
+
+//EncoderFunc adapter to use ordinary functions as encoders
+type EncoderFunc struct {
+	Type string
+	Func func(output map[string]interface{}) ([]byte, error)
+}
+
+//ContentType returns Type
+func (e EncoderFunc) ContentType() string {
+	return e.Type
+}
+
+//Encode calls Func(output)
+func (e EncoderFunc) Encode(output map[string]interface{}) ([]byte, error) {
+	return e.Func(output)
+}
+
+//DefaultMediaType media type used when the client doesn't send an Accept header
+const DefaultMediaType = "application/json"
+
+var (
+	//JSONEncoder renders output as compact JSON
+	JSONEncoder Encoder = EncoderFunc{Type: "application/json", Func: func(output map[string]interface{}) ([]byte, error) {
+		return json.Marshal(output)
+	}}
+	//PrettyJSONEncoder renders output as indented JSON
+	PrettyJSONEncoder Encoder = EncoderFunc{Type: "application/json", Func: func(output map[string]interface{}) ([]byte, error) {
+		return json.MarshalIndent(output, "", "  ")
+	}}
+	//YAMLEncoder renders output as YAML
+	YAMLEncoder Encoder = EncoderFunc{Type: "application/yaml", Func: func(output map[string]interface{}) ([]byte, error) {
+		return yaml.Marshal(output)
+	}}
+	//MessagePackEncoder renders output as MessagePack
+	MessagePackEncoder Encoder = EncoderFunc{Type: "application/msgpack", Func: func(output map[string]interface{}) ([]byte, error) {
+		return msgpack.Marshal(output)
+	}}
+	//PropertiesEncoder renders output as flat "key=value" lines. Nested attributes are rendered with dotted keys (ex.: "menu.title=Home", "tags.0=a")
+	PropertiesEncoder Encoder = EncoderFunc{Type: "text/plain; charset=utf-8", Func: encodeProperties}
+)
+
+var encoders = map[string]Encoder{
+	"application/json":          JSONEncoder,
+	"application/x-pretty-json": PrettyJSONEncoder,
+	"application/yaml":          YAMLEncoder,
+	"application/x-yaml":        YAMLEncoder,
+	"text/yaml":                 YAMLEncoder,
+	"application/msgpack":       MessagePackEncoder,
+	"application/x-msgpack":     MessagePackEncoder,
+	"text/x-java-properties":    PropertiesEncoder,
+	"text/plain":                PropertiesEncoder,
+}
+
+//RegisterEncoder registers an encoder for a media type requested in the Accept header. Existing registrations are replaced
+func RegisterEncoder(mediaType string, encoder Encoder) {
+	encoders[strings.ToLower(mediaType)] = encoder
+}
+
+//negotiateEncoder chooses the encoder for the media types accepted by the client, honoring quality values.
+//Returns the requested media type that was chosen along with its encoder
+func negotiateEncoder(accept string) (string, Encoder, error) {
+	if strings.TrimSpace(accept) == "" {
+		return DefaultMediaType, encoders[DefaultMediaType], nil
+	}
+
+	type mediaRange struct {
+		mediaType string
+		q         float64
+	}
+	ranges := make([]mediaRange, 0)
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if qs, exists := params["q"]; exists {
+			q, err = strconv.ParseFloat(qs, 64)
+			if err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, mediaRange{mediaType: mediaType, q: q})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+
+	for _, mr := range ranges {
+		if mr.mediaType == "*/*" {
+			return DefaultMediaType, encoders[DefaultMediaType], nil
+		}
+		if strings.HasSuffix(mr.mediaType, "/*") {
+			if strings.HasPrefix(DefaultMediaType, strings.TrimSuffix(mr.mediaType, "*")) {
+				return DefaultMediaType, encoders[DefaultMediaType], nil
+			}
+			for _, mediaType := range sortedMediaTypes() {
+				if strings.HasPrefix(mediaType, strings.TrimSuffix(mr.mediaType, "*")) {
+					return mediaType, encoders[mediaType], nil
+				}
+			}
+			continue
+		}
+		if encoder, exists := encoders[mr.mediaType]; exists {
+			return mr.mediaType, encoder, nil
+		}
+	}
+	return "", nil, fmt.Errorf("None of the accepted media types is supported: %s", accept)
+}
+
+func sortedMediaTypes() []string {
+	mediaTypes := make([]string, 0, len(encoders))
+	for mediaType := range encoders {
+		mediaTypes = append(mediaTypes, mediaType)
+	}
+	sort.Strings(mediaTypes)
+	return mediaTypes
+}
+
+func encodeProperties(output map[string]interface{}) ([]byte, error) {
+	lines := make([]string, 0)
+	appendProperties(&lines, "", output)
+	sort.Strings(lines)
+	var buf bytes.Buffer
+	for _, line := range lines {
+		buf.WriteString(line)
+		buf.WriteString("\n")
+	}
+	return buf.Bytes(), nil
+}
+
+func appendProperties(lines *[]string, prefix string, v interface{}) {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for k, child := range value {
+			appendProperties(lines, prefix+propertiesEscaper.Replace(k)+".", child)
+		}
+		return
+	case nil:
+		*lines = append(*lines, strings.TrimSuffix(prefix, ".")+"=")
+		return
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Slice {
+		for i := 0; i < rv.Len(); i++ {
+			appendProperties(lines, fmt.Sprintf("%s%d.", prefix, i), rv.Index(i).Interface())
+		}
+		return
+	}
+	*lines = append(*lines, strings.TrimSuffix(prefix, ".")+"="+propertiesEscaper.Replace(valueString(v)))
+}
+
+//propertiesEscaper escapes separators, whitespace and line breaks of property keys and values
+var propertiesEscaper = strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\r", "\\r", "\t", "\\t", " ", "\\ ", "=", "\\=", ":", "\\:")
+
+//valueString formats scalar values as text. Floats are formatted without exponents, so that large numbers such as ids (1234567) are kept as is
+func valueString(v interface{}) string {
+	switch n := v.(type) {
+	case float64:
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(n), 'f', -1, 32)
+	}
+	return fmt.Sprintf("%v", v)
+}
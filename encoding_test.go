@@ -0,0 +1,68 @@
+package ruller
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+func TestResponseEncoding(t *testing.T) {
+	SetDefaultTreeOutput("encoding", true)
+	Add("encoding", "rule1", func(ctx Context) (map[string]interface{}, error) {
+		return map[string]interface{}{"theme": "dark", "menu": map[string]interface{}{"title": "Home"}, "tags": []interface{}{"a", "b"}}, nil
+	})
+
+	evaluate := func(accept string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("POST", "/rules/encoding", bytes.NewBufferString(`{"_flatten":true}`))
+		r.Header.Set("Accept", accept)
+		r = mux.SetURLVars(r, map[string]string{"groupName": "encoding"})
+		w := httptest.NewRecorder()
+		HandleRuleGroup(w, r)
+		return w
+	}
+
+	w := evaluate("")
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"theme":"dark","menu":{"title":"Home"},"tags":["a","b"]}`, w.Body.String())
+
+	assert.Equal(t, "Accept", w.Header().Get("Vary"))
+
+	w = evaluate("application/yaml")
+	assert.Equal(t, "application/yaml", w.Header().Get("Content-Type"))
+	assert.Equal(t, "Accept", w.Header().Get("Vary"))
+	assert.Contains(t, w.Body.String(), "theme: dark\n")
+
+	w = evaluate("text/x-java-properties")
+	assert.Equal(t, "menu.title=Home\ntags.0=a\ntags.1=b\ntheme=dark\n", w.Body.String())
+
+	props, err := encodeProperties(map[string]interface{}{"id": 1234567.0, "big": 12345678901.0, "ratio": 0.5, "a b=c:d": "x y\nz=1"})
+	assert.Nil(t, err)
+	assert.Equal(t, "a\\ b\\=c\\:d=x\\ y\\nz\\=1\nbig=12345678901\nid=1234567\nratio=0.5\n", string(props))
+
+	w = evaluate("application/json;q=0.5, application/msgpack")
+	assert.Equal(t, "application/msgpack", w.Header().Get("Content-Type"))
+	output := make(map[string]interface{})
+	assert.Nil(t, msgpack.Unmarshal(w.Body.Bytes(), &output))
+	assert.Equal(t, "dark", output["theme"])
+
+	w = evaluate("application/x-pretty-json")
+	assert.Contains(t, w.Body.String(), "\n  \"theme\": \"dark\"")
+
+	w = evaluate("image/png")
+	assert.Equal(t, http.StatusNotAcceptable, w.Code)
+
+	RegisterEncoder("text/csv", EncoderFunc{Type: "text/csv", Func: func(output map[string]interface{}) ([]byte, error) {
+		return []byte("theme\n" + output["theme"].(string) + "\n"), nil
+	}})
+	w = evaluate("text/csv")
+	assert.Equal(t, "theme\ndark\n", w.Body.String())
+
+	mediaType, _, err := negotiateEncoder("text/*;q=0.9, */*;q=0.1")
+	assert.Nil(t, err)
+	assert.Equal(t, "text/csv", mediaType)
+}
@@ -0,0 +1,164 @@
+package ruller
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+//Enricher adds attributes to the input before rules processing, such as the location of the client IP.
+//The request is nil when enrichment is requested from the Go API (see Enrich)
+type Enricher interface {
+	Enrich(ctx context.Context, r *http.Request, input map[string]interface{}) error
+}
+
+//EnricherFunc adapter to use ordinary functions as enrichers
+type EnricherFunc func(ctx context.Context, r *http.Request, input map[string]interface{}) error
+
+//Enrich calls f(ctx, r, input)
+func (f EnricherFunc) Enrich(ctx context.Context, r *http.Request, input map[string]interface{}) error {
+	return f(ctx, r, input)
+}
+
+type enricherEntry struct {
+	enricher Enricher
+	provides []string
+}
+
+var (
+	groupEnrichers = map[string][]enricherEntry{"*": {{GeoIPEnricher, geoIPAttributes}}} //[group]. "*" for all groups. guarded by registryMutex
+	groupInputs    = make(map[string]map[string]bool)                                  //[group][input name]. guarded by registryMutex
+)
+
+//GeoIPEnricher adds the location of "_remote_ip" to the input using the GeoLite2 and City State databases ("--geolite2-db" and "--city-state-db").
+//When the databases are not loaded, attributes are set with empty values. Registered for all groups by default
+var GeoIPEnricher Enricher = EnricherFunc(enrichGeoIP)
+
+var geoIPAttributes = []string{"_ip_country", "_ip_city", "_ip_state", "_ip_latitude", "_ip_longitude", "_ip_accuracy_radius"}
+
+//AddEnricher adds an enricher to a group. Use "*" as group name to add it to all groups. Enrichers run in the order they were added, the ones of all groups first.
+//'provides' are the input attributes added by the enricher. When declared, the enricher only runs for groups that use some of them (see DeclareInputs),
+//and clients can't send them in requests, even when the enricher is skipped
+func AddEnricher(groupName string, enricher Enricher, provides ...string) {
+	logrus.Debugf("Adding enricher. group=%s. provides=%s", groupName, provides)
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	groupEnrichers[groupName] = append(groupEnrichers[groupName], enricherEntry{enricher: enricher, provides: provides})
+}
+
+//DeclareInputs declares the input attributes used by the rules of a group, in addition to the required ones (see AddRequiredInput).
+//Once declared, enrichers that provide none of them are skipped for the group
+func DeclareInputs(groupName string, inputNames ...string) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	gi, exists := groupInputs[groupName]
+	if !exists {
+		gi = make(map[string]bool)
+		groupInputs[groupName] = gi
+	}
+	for _, inputName := range inputNames {
+		gi[inputName] = true
+	}
+}
+
+//Enrich runs the enrichers of a group on input. Use it before Process or Evaluate to have the same attributes the REST API adds to the input
+func Enrich(ctx context.Context, groupName string, input map[string]interface{}) error {
+	return enrich(ctx, nil, groupName, input)
+}
+
+func enrich(ctx context.Context, r *http.Request, groupName string, input map[string]interface{}) error {
+	//enrichers may be slow, so they run without holding the registry lock
+	for _, entry := range neededEnrichers(groupName) {
+		err := entry.enricher.Enrich(ctx, r, input)
+		if err != nil {
+			return fmt.Errorf("Error enriching input. err=%s", err)
+		}
+	}
+	return nil
+}
+
+//neededEnrichers the enrichers of a group (the ones of all groups first) that provide attributes used by the group
+func neededEnrichers(groupName string) []enricherEntry {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	entries := make([]enricherEntry, 0)
+	for _, entry := range append(append([]enricherEntry{}, groupEnrichers["*"]...), groupEnrichers[groupName]...) {
+		if enricherNeeded(groupName, entry.provides) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+//stripEnrichedInputs removes from input the attributes provided by the enrichers of a group, including skipped ones, so that clients can't forge them
+func stripEnrichedInputs(groupName string, input map[string]interface{}) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	for _, entries := range [][]enricherEntry{groupEnrichers["*"], groupEnrichers[groupName]} {
+		for _, entry := range entries {
+			for _, inputName := range entry.provides {
+				delete(input, inputName)
+			}
+		}
+	}
+}
+
+//enricherNeeded whatever a group uses some of the attributes provided by an enricher. True when either side didn't declare them
+func enricherNeeded(groupName string, provides []string) bool {
+	gi, declared := groupInputs[groupName]
+	if !declared || len(provides) == 0 {
+		return true
+	}
+	for _, inputName := range provides {
+		if _, required := requiredInputNames[groupName][inputName]; required || gi[inputName] {
+			return true
+		}
+	}
+	return false
+}
+
+func enrichGeoIP(ctx context.Context, r *http.Request, input map[string]interface{}) error {
+	input["_ip_country"] = ""
+	input["_ip_city"] = ""
+	input["_ip_state"] = ""
+	input["_ip_latitude"] = 0
+	input["_ip_longitude"] = 0
+	input["_ip_accuracy_radius"] = 999999
+
+	if geodb == nil {
+		return nil
+	}
+	ipStr := fmt.Sprintf("%v", input["_remote_ip"])
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		logrus.Debugf("Couldn't find geo info for invalid ip %s", ipStr)
+		return nil
+	}
+	start := time.Now()
+	ipRecord, err := geodb.City(ip)
+	logrus.Debugf("Time to find getIp data: %s", time.Since(start))
+	if err != nil {
+		logrus.Warnf("Couldn't find geo info for ip %s. err=%s", ipStr, err)
+		return nil
+	}
+	input["_ip_country"] = ipRecord.Country.Names["en"]
+	input["_ip_city"] = ipRecord.City.Names["en"]
+	input["_ip_latitude"] = ipRecord.Location.Latitude
+	input["_ip_longitude"] = ipRecord.Location.Longitude
+	input["_ip_accuracy_radius"] = ipRecord.Location.AccuracyRadius
+
+	//get state from city name
+	cs, exists := cityState[strings.ToLower(ipRecord.Country.IsoCode)]
+	if exists {
+		state, exists := cs[strings.ToLower(ipRecord.City.Names["en"])]
+		if exists {
+			input["_ip_state"] = state
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package ruller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnrichers(t *testing.T) {
+	SetDefaultTreeOutput("enrichers", true)
+	Add("enrichers", "rule1", func(ctx Context) (map[string]interface{}, error) {
+		_, geo := ctx.Input["_ip_country"]
+		return map[string]interface{}{"tier": ctx.Input["_tier"], "discount": ctx.Input["_discount"], "geo": geo}, nil
+	})
+	AddEnricher("enrichers", EnricherFunc(func(ctx context.Context, r *http.Request, input map[string]interface{}) error {
+		if r == nil {
+			input["_tier"] = "gold"
+			return nil
+		}
+		if r.Header.Get("X-Tier") == "fail" {
+			return fmt.Errorf("tier service unavailable")
+		}
+		input["_tier"] = r.Header.Get("X-Tier")
+		return nil
+	}), "_tier")
+	//runs after the previous enricher
+	AddEnricher("enrichers", EnricherFunc(func(ctx context.Context, r *http.Request, input map[string]interface{}) error {
+		if input["_tier"] == "gold" {
+			input["_discount"] = 0.1
+		}
+		return nil
+	}), "_discount")
+
+	evaluate := func(tier string) (int, map[string]interface{}) {
+		r, _ := http.NewRequest("POST", "/rules/enrichers", bytes.NewBufferString(`{"_flatten":true}`))
+		r.Header.Set("X-Tier", tier)
+		r = mux.SetURLVars(r, map[string]string{"groupName": "enrichers"})
+		w := httptest.NewRecorder()
+		HandleRuleGroup(w, r)
+		output := make(map[string]interface{})
+		json.Unmarshal(w.Body.Bytes(), &output)
+		return w.Code, output
+	}
+
+	code, output := evaluate("gold")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]interface{}{"tier": "gold", "discount": 0.1, "geo": true}, output)
+
+	code, _ = evaluate("fail")
+	assert.Equal(t, http.StatusInternalServerError, code)
+
+	//GeoIP and discount enrichers are skipped when the group declares it doesn't use their attributes
+	DeclareInputs("enrichers", "_tier")
+	code, output = evaluate("silver")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]interface{}{"tier": "silver", "discount": nil, "geo": false}, output)
+
+	//attributes sent by clients are removed even when their enrichers are skipped
+	r, _ := http.NewRequest("POST", "/rules/enrichers", bytes.NewBufferString(`{"_flatten":true,"_ip_country":"Forged","_discount":0.9}`))
+	r = mux.SetURLVars(r, map[string]string{"groupName": "enrichers"})
+	w := httptest.NewRecorder()
+	HandleRuleGroup(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "Forged")
+	assert.Contains(t, w.Body.String(), `"geo":false`)
+	assert.Contains(t, w.Body.String(), `"discount":null`)
+	AddRequiredInput("enrichers", "_discount", Float64)
+	code, output = evaluate("gold")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 0.1, output["discount"])
+	delete(requiredInputNames, "enrichers")
+
+	//Go API
+	input := map[string]interface{}{"_remote_ip": "1.2.3.4"}
+	assert.Nil(t, Enrich(context.Background(), "enrichers", input))
+	output, err := Process("enrichers", input, ProcessOptions{FlattenOutput: true})
+	assert.Nil(t, err)
+	assert.Equal(t, "gold", output["tier"])
+}
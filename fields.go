@@ -0,0 +1,134 @@
+package ruller
+
+import (
+	"fmt"
+	"strings"
+)
+
+//SetOutputKeys declares the top level output keys a rule may produce (including keys coming from its children).
+//This allows rules that can't contribute to the requested "_fields" to be skipped. Rules that declare output keys are not expected to return ErrStopProcessing
+func SetOutputKeys(groupName string, ruleName string, keys ...string) error {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	rinfo, exists := rulesMap[groupName][ruleName]
+	if !exists {
+		return fmt.Errorf("Rule '%s' not found in group '%s'", ruleName, groupName)
+	}
+	rinfo.outputKeys = append(make([]string, 0, len(keys)), keys...)
+	return nil
+}
+
+//mayContribute returns false only when it is known from declared output keys that neither the rule nor its children produce any of the requested fields
+func (e *evaluation) mayContribute(rinfo *ruleInfo) bool {
+	if len(e.options.Fields) == 0 || !e.options.FlattenOutput || rinfo.outputKeys == nil {
+		return true
+	}
+	for _, key := range rinfo.outputKeys {
+		for _, field := range e.options.Fields {
+			if key == strings.SplitN(field, ".", 2)[0] {
+				return true
+			}
+		}
+	}
+	for _, child := range rinfo.children {
+		if e.mayContribute(child) {
+			return true
+		}
+	}
+	return false
+}
+
+//projectFields returns a new map with only the values found at the dotted paths in fields
+func projectFields(output map[string]interface{}, fields []string) map[string]interface{} {
+	result := make(map[string]interface{})
+	for _, field := range fields {
+		path := strings.Split(field, ".")
+		src := output
+		dst := result
+		for i, segment := range path {
+			v, exists := src[segment]
+			if !exists {
+				break
+			}
+			if i == len(path)-1 {
+				dst[segment] = v
+				break
+			}
+			vm, ok := v.(map[string]interface{})
+			if !ok {
+				break
+			}
+			dm, ok := dst[segment].(map[string]interface{})
+			if !ok {
+				dm = make(map[string]interface{})
+				dst[segment] = dm
+			}
+			src = vm
+			dst = dm
+		}
+	}
+	return result
+}
+
+//projectTreeFields projects the output of each rule node of a tree output (see ProcessOptions.TreeOutput). Nodes with nothing left are removed.
+//Other attributes (such as overrides of attributes no rule returned) are projected as in projectFields
+func projectTreeFields(output map[string]interface{}, fields []string) map[string]interface{} {
+	result := make(map[string]interface{})
+	others := make(map[string]interface{})
+	for name, v := range output {
+		node, ok := v.(map[string]interface{})
+		routput, isNode := node["output"].(map[string]interface{})
+		if !ok || !isNode {
+			others[name] = v
+			continue
+		}
+		projected := make(map[string]interface{}, len(node))
+		for k, nv := range node {
+			projected[k] = nv
+		}
+		projected["output"] = projectFields(routput, fields)
+		delete(projected, "children")
+		if children, ok := node["children"].(map[string]interface{}); ok {
+			if pchildren := projectTreeFields(children, fields); len(pchildren) > 0 {
+				projected["children"] = pchildren
+			}
+		}
+		if len(projected["output"].(map[string]interface{})) == 0 && projected["children"] == nil {
+			continue
+		}
+		result[name] = projected
+	}
+	for k, v := range projectFields(others, fields) {
+		result[k] = v
+	}
+	return result
+}
+
+//getFields reads a list of fields either as an array of strings or as a comma separated string
+func getFields(vmap map[string]interface{}, vkey string) ([]string, error) {
+	value, exists := vmap[vkey]
+	if !exists {
+		return nil, nil
+	}
+	switch v := value.(type) {
+	case string:
+		fields := make([]string, 0)
+		for _, f := range strings.Split(v, ",") {
+			if strings.TrimSpace(f) != "" {
+				fields = append(fields, strings.TrimSpace(f))
+			}
+		}
+		return fields, nil
+	case []interface{}:
+		fields := make([]string, 0, len(v))
+		for _, f := range v {
+			fs, ok := f.(string)
+			if !ok {
+				return nil, fmt.Errorf("'%s' must be a list of strings", vkey)
+			}
+			fields = append(fields, fs)
+		}
+		return fields, nil
+	}
+	return nil, fmt.Errorf("'%s' must be a list of strings", vkey)
+}
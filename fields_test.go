@@ -0,0 +1,39 @@
+package ruller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutputFields(t *testing.T) {
+	calls := make(map[string]int)
+	Add("fields", "menu", func(ctx Context) (map[string]interface{}, error) {
+		calls["menu"]++
+		return map[string]interface{}{"menu": map[string]interface{}{"title": "Home", "items": 3.0}}, nil
+	})
+	Add("fields", "theme", func(ctx Context) (map[string]interface{}, error) {
+		calls["theme"]++
+		return map[string]interface{}{"theme": "dark", "font": "sans"}, nil
+	})
+	Add("fields", "undeclared", func(ctx Context) (map[string]interface{}, error) {
+		calls["undeclared"]++
+		return map[string]interface{}{"other": true}, nil
+	})
+	assert.Nil(t, SetOutputKeys("fields", "menu", "menu"))
+	assert.Nil(t, SetOutputKeys("fields", "theme", "theme", "font"))
+	assert.NotNil(t, SetOutputKeys("fields", "unknown", "x"))
+
+	options := ProcessOptions{FlattenOutput: true, MergeKeepFirst: true, Fields: []string{"menu.title", "other"}}
+	output, err := Process("fields", map[string]interface{}{}, options)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"menu": map[string]interface{}{"title": "Home"}, "other": true}, output)
+	assert.Equal(t, 0, calls["theme"])
+	assert.Equal(t, 1, calls["undeclared"])
+
+	fields, err := getFields(map[string]interface{}{"_fields": "menu, theme"}, "_fields")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"menu", "theme"}, fields)
+	_, err = getFields(map[string]interface{}{"_fields": 1.0}, "_fields")
+	assert.NotNil(t, err)
+}
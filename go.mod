@@ -3,9 +3,14 @@ module github.com/flaviostutz/ruller
 go 1.13
 
 require (
+	github.com/golang/protobuf v1.3.4
 	github.com/gorilla/mux v1.7.4
+	github.com/gorilla/websocket v1.5.3
 	github.com/oschwald/geoip2-golang v1.4.0
 	github.com/prometheus/client_golang v1.5.1
 	github.com/sirupsen/logrus v1.5.0
 	github.com/stretchr/testify v1.4.0
+	github.com/vmihailenco/msgpack/v4 v4.3.12
+	google.golang.org/grpc v1.29.1
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,320 @@
+package ruller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+
+	structpb "github.com/golang/protobuf/ptypes/struct"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+//rullerService gRPC service described in ruller.proto. Requests and responses use google.protobuf.Struct so that no per group code generation is needed
+type rullerService interface {
+	//Evaluate request {"group": "..", "input": {..}}. Returns the output of the group rules
+	Evaluate(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)
+	//EvaluateBatch request {"group": "..", "inputs": [{..}, ..]}. Returns {"outputs": [..], "errors": [..]} with one entry per input. Failed inputs have a null output and a non empty error
+	EvaluateBatch(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)
+	//WatchGroup request {"group": "..", "input": {..}}. Sends the output of the group rules and sends it again whenever it changes because of runtime changes to the group
+	WatchGroup(req *structpb.Struct, stream grpc.ServerStream) error
+}
+
+type grpcService struct{}
+
+var rullerServiceDesc = grpc.ServiceDesc{
+	ServiceName: "ruller.Ruller",
+	HandlerType: (*rullerService)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Evaluate",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				return unaryHandler(ctx, srv.(rullerService).Evaluate, "/ruller.Ruller/Evaluate", dec, interceptor)
+			},
+		},
+		{
+			MethodName: "EvaluateBatch",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				return unaryHandler(ctx, srv.(rullerService).EvaluateBatch, "/ruller.Ruller/EvaluateBatch", dec, interceptor)
+			},
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchGroup",
+			ServerStreams: true,
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				req := new(structpb.Struct)
+				if err := stream.RecvMsg(req); err != nil {
+					return err
+				}
+				return srv.(rullerService).WatchGroup(req, stream)
+			},
+		},
+	},
+	Metadata: "ruller.proto",
+}
+
+func unaryHandler(ctx context.Context, method func(context.Context, *structpb.Struct) (*structpb.Struct, error), fullMethod string, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	req := new(structpb.Struct)
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return method(ctx, req)
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: fullMethod}
+	return interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return method(ctx, req.(*structpb.Struct))
+	})
+}
+
+//NewGRPCServer creates a gRPC server with the ruller service registered. Evaluations share input enrichment, request filter and metrics with the REST API
+func NewGRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	server := grpc.NewServer(opts...)
+	server.RegisterService(&rullerServiceDesc, grpcService{})
+	return server
+}
+
+func (grpcService) Evaluate(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	groupName, input, err := grpcGroupInput(req, "input")
+	if err != nil {
+		return nil, err
+	}
+	r, err := grpcAuthorizedRequest(ctx, groupName)
+	if err != nil {
+		return nil, err
+	}
+	output, err := evaluateRequest(r, groupName, input)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return toStruct(output)
+}
+
+func (grpcService) EvaluateBatch(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	groupName, _, err := grpcGroupInput(req, "")
+	if err != nil {
+		return nil, err
+	}
+	inputs, ok := fromValue(req.Fields["inputs"]).([]interface{})
+	if !ok {
+		return nil, status.Error(codes.InvalidArgument, "'inputs' must be a list of maps")
+	}
+	r, err := grpcAuthorizedRequest(ctx, groupName)
+	if err != nil {
+		return nil, err
+	}
+
+	outputs := make([]interface{}, len(inputs))
+	errors := make([]interface{}, len(inputs))
+	for i, in := range inputs {
+		input, ok := in.(map[string]interface{})
+		if !ok {
+			errors[i] = "input must be a map"
+			continue
+		}
+		output, err := evaluateRequest(r, groupName, input)
+		if err != nil {
+			errors[i] = err.Error()
+			continue
+		}
+		outputs[i] = output
+		errors[i] = ""
+	}
+	return toStruct(map[string]interface{}{"outputs": outputs, "errors": errors})
+}
+
+func (grpcService) WatchGroup(req *structpb.Struct, stream grpc.ServerStream) error {
+	groupName, input, err := grpcGroupInput(req, "input")
+	if err != nil {
+		return err
+	}
+	r, err := grpcAuthorizedRequest(stream.Context(), groupName)
+	if err != nil {
+		return err
+	}
+	changes, stop := watchGroup(groupName)
+	defer stop()
+
+	var last map[string]interface{}
+	for first := true; ; first = false {
+		//only the first evaluation counts against request limits
+		output, err := evaluateRequestLimited(r, groupName, copyMap(input), first)
+		if err != nil {
+			return grpcError(err)
+		}
+		if last == nil || !reflect.DeepEqual(last, output) {
+			res, err := toStruct(output)
+			if err != nil {
+				return err
+			}
+			err = stream.SendMsg(res)
+			if err != nil {
+				return err
+			}
+			last = output
+		}
+
+		select {
+		case <-changes:
+			logrus.Debugf("Group %s changed. Reevaluating for watcher", groupName)
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+func grpcGroupInput(req *structpb.Struct, inputField string) (string, map[string]interface{}, error) {
+	groupName, ok := fromValue(req.Fields["group"]).(string)
+	if !ok || groupName == "" {
+		return "", nil, status.Error(codes.InvalidArgument, "'group' is required")
+	}
+	input := make(map[string]interface{})
+	if inputField != "" && req.Fields[inputField] != nil {
+		input, ok = fromValue(req.Fields[inputField]).(map[string]interface{})
+		if !ok {
+			return "", nil, status.Errorf(codes.InvalidArgument, "'%s' must be a map", inputField)
+		}
+	}
+	return groupName, input, nil
+}
+
+//grpcHTTPRequest creates an http request equivalent to a gRPC call so that request filters and enrichment work the same way for both APIs.
+//gRPC metadata becomes headers, the peer address becomes the remote address and the peer TLS state (with client certificates) becomes the request TLS state
+func grpcHTTPRequest(ctx context.Context, groupName string) *http.Request {
+	r, _ := http.NewRequest("POST", "/rules/"+url.PathEscape(groupName), http.NoBody)
+	r = r.WithContext(ctx)
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for k, vs := range md {
+			for _, v := range vs {
+				r.Header.Add(k, v)
+			}
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if p.Addr != nil {
+			r.RemoteAddr = p.Addr.String()
+		}
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			r.TLS = &tlsInfo.State
+		}
+	}
+	return r
+}
+
+//grpcAuthorizedRequest creates the http request equivalent to a gRPC call, authenticated and authorized as a REST call to the group would be
+func grpcAuthorizedRequest(ctx context.Context, groupName string) (*http.Request, error) {
+	r, p, err := authenticate(grpcHTTPRequest(ctx, groupName))
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	err = authorize(p, groupName, false)
+	if err != nil {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	return r, nil
+}
+
+func grpcError(err error) error {
+	switch errorStatus(err) {
+	case http.StatusBadRequest:
+		return status.Error(codes.InvalidArgument, err.Error())
+	case http.StatusTooManyRequests:
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case http.StatusNotFound:
+		return status.Error(codes.NotFound, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func toStruct(m map[string]interface{}) (*structpb.Struct, error) {
+	s := &structpb.Struct{Fields: make(map[string]*structpb.Value, len(m))}
+	for k, v := range m {
+		value, err := toValue(v)
+		if err != nil {
+			return nil, err
+		}
+		s.Fields[k] = value
+	}
+	return s, nil
+}
+
+func toValue(v interface{}) (*structpb.Value, error) {
+	switch value := v.(type) {
+	case nil:
+		return &structpb.Value{Kind: &structpb.Value_NullValue{}}, nil
+	case bool:
+		return &structpb.Value{Kind: &structpb.Value_BoolValue{BoolValue: value}}, nil
+	case string:
+		return &structpb.Value{Kind: &structpb.Value_StringValue{StringValue: value}}, nil
+	case map[string]interface{}:
+		s, err := toStruct(value)
+		if err != nil {
+			return nil, err
+		}
+		return &structpb.Value{Kind: &structpb.Value_StructValue{StructValue: s}}, nil
+	}
+	if f, ok := toFloat64(v); ok {
+		return &structpb.Value{Kind: &structpb.Value_NumberValue{NumberValue: f}}, nil
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Slice {
+		list := &structpb.ListValue{Values: make([]*structpb.Value, rv.Len())}
+		for i := 0; i < rv.Len(); i++ {
+			item, err := toValue(rv.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			list.Values[i] = item
+		}
+		return &structpb.Value{Kind: &structpb.Value_ListValue{ListValue: list}}, nil
+	}
+
+	//other types (structs, typed maps etc) are converted as encoding/json would do
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't convert %v to protobuf value. err=%s", v, err)
+	}
+	var generic interface{}
+	err = json.Unmarshal(b, &generic)
+	if err != nil {
+		return nil, err
+	}
+	return toValue(generic)
+}
+
+func fromValue(v *structpb.Value) interface{} {
+	if v == nil {
+		return nil
+	}
+	switch kind := v.Kind.(type) {
+	case *structpb.Value_BoolValue:
+		return kind.BoolValue
+	case *structpb.Value_StringValue:
+		return kind.StringValue
+	case *structpb.Value_NumberValue:
+		return kind.NumberValue
+	case *structpb.Value_StructValue:
+		m := make(map[string]interface{}, len(kind.StructValue.Fields))
+		for k, fv := range kind.StructValue.Fields {
+			m[k] = fromValue(fv)
+		}
+		return m
+	case *structpb.Value_ListValue:
+		arr := make([]interface{}, len(kind.ListValue.Values))
+		for i, lv := range kind.ListValue.Values {
+			arr[i] = fromValue(lv)
+		}
+		return arr
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package ruller
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	structpb "github.com/golang/protobuf/ptypes/struct"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestGRPCAPI(t *testing.T) {
+	Add("grpc", "rule1", func(ctx Context) (map[string]interface{}, error) {
+		return map[string]interface{}{"age": ctx.Input["age"], "ip": ctx.Input["_remote_ip"]}, nil
+	})
+	AddRequiredInput("grpc", "age", Float64)
+
+	lis := bufconn.Listen(1024 * 1024)
+	server := NewGRPCServer()
+	go server.Serve(lis)
+	defer server.Stop()
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) {
+		return lis.Dial()
+	}))
+	assert.Nil(t, err)
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, _ := toStruct(map[string]interface{}{"group": "grpc", "input": map[string]interface{}{"age": 22, "_flatten": true}})
+	res := new(structpb.Struct)
+	err = conn.Invoke(ctx, "/ruller.Ruller/Evaluate", req, res)
+	assert.Nil(t, err)
+	assert.Equal(t, 22.0, fromValue(res.Fields["age"]))
+	assert.NotEmpty(t, fromValue(res.Fields["ip"]))
+
+	req, _ = toStruct(map[string]interface{}{"group": "grpc", "inputs": []interface{}{
+		map[string]interface{}{"age": 30, "_flatten": true},
+		map[string]interface{}{"_flatten": true},
+	}})
+	res = new(structpb.Struct)
+	err = conn.Invoke(ctx, "/ruller.Ruller/EvaluateBatch", req, res)
+	assert.Nil(t, err)
+	outputs := fromValue(res.Fields["outputs"]).([]interface{})
+	errors := fromValue(res.Fields["errors"]).([]interface{})
+	assert.Equal(t, 30.0, outputs[0].(map[string]interface{})["age"])
+	assert.Nil(t, outputs[1])
+	assert.Contains(t, errors[1], "Missing required input")
+
+	req, _ = toStruct(map[string]interface{}{"group": "grpc", "input": map[string]interface{}{"age": 40, "userId": "u1", "_flatten": true}})
+	stream, err := conn.NewStream(ctx, &rullerServiceDesc.Streams[0], "/ruller.Ruller/WatchGroup")
+	assert.Nil(t, err)
+	assert.Nil(t, stream.SendMsg(req))
+	assert.Nil(t, stream.CloseSend())
+	res = new(structpb.Struct)
+	assert.Nil(t, stream.RecvMsg(res))
+	assert.Equal(t, 40.0, fromValue(res.Fields["age"]))
+
+	assert.Nil(t, SetOverride("grpc", "userId", "u1", map[string]interface{}{"age": 99}, "tester"))
+	res = new(structpb.Struct)
+	assert.Nil(t, stream.RecvMsg(res))
+	assert.Equal(t, 99.0, fromValue(res.Fields["age"]))
+
+	//re-evaluations of a watch don't count against rate limits
+	assert.Nil(t, SetRateLimit("grpc", RateLimit{Key: "ip", Rate: 0.001, Burst: 1}))
+	defer func() {
+		limitersMutex.Lock()
+		delete(groupLimiters, "grpc")
+		limitersMutex.Unlock()
+	}()
+	req, _ = toStruct(map[string]interface{}{"group": "grpc", "input": map[string]interface{}{"age": 50, "userId": "u2", "_flatten": true}})
+	stream, err = conn.NewStream(ctx, &rullerServiceDesc.Streams[0], "/ruller.Ruller/WatchGroup")
+	assert.Nil(t, err)
+	assert.Nil(t, stream.SendMsg(req))
+	assert.Nil(t, stream.CloseSend())
+	res = new(structpb.Struct)
+	assert.Nil(t, stream.RecvMsg(res))
+	assert.Equal(t, 50.0, fromValue(res.Fields["age"]))
+	assert.Nil(t, SetOverride("grpc", "userId", "u2", map[string]interface{}{"age": 98}, "tester"))
+	res = new(structpb.Struct)
+	assert.Nil(t, stream.RecvMsg(res))
+	assert.Equal(t, 98.0, fromValue(res.Fields["age"]))
+}
@@ -0,0 +1,135 @@
+package ruller
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+//Version ruller build version shown in /info. Set it at build time with -ldflags "-X github.com/flaviostutz/ruller.Version=1.2.3"
+var Version = "dev"
+
+//ReadinessCheck returns an error while a dependency needed to serve requests is not ready
+type ReadinessCheck func() error
+
+//BuildInfo build and rule set information shown in /info
+type BuildInfo struct {
+	Version   string               `json:"version"`
+	StartTime time.Time            `json:"startTime"`
+	Groups    map[string]GroupInfo `json:"groups"`
+}
+
+//GroupInfo rule count and rule set hash of a group. The hash changes whenever rules, priorities, strategies, inputs, outputs or enabled states change
+type GroupInfo struct {
+	Rules int    `json:"rules"`
+	Hash  string `json:"hash"`
+}
+
+var (
+	startTime       = time.Now()
+	readinessChecks = make(map[string]ReadinessCheck)
+	readinessMutex  sync.RWMutex
+)
+
+//AddReadinessCheck registers a check used by /readyz. Rule authors may use it for the dependencies of their rules. Checks with the same name are replaced
+func AddReadinessCheck(name string, check ReadinessCheck) {
+	readinessMutex.Lock()
+	defer readinessMutex.Unlock()
+	readinessChecks[name] = check
+}
+
+//Readiness runs all readiness checks. Returns the result of each check by name ("ok" or the error message) and whatever all of them passed
+func Readiness() (map[string]string, bool) {
+	readinessMutex.RLock()
+	checks := make(map[string]ReadinessCheck, len(readinessChecks))
+	for name, check := range readinessChecks {
+		checks[name] = check
+	}
+	readinessMutex.RUnlock()
+	checks["bundles"] = checkBundles
+	return runChecks(checks)
+}
+
+func runChecks(checks map[string]ReadinessCheck) (map[string]string, bool) {
+	results := make(map[string]string, len(checks))
+	ready := true
+	for name, check := range checks {
+		err := check()
+		if err != nil {
+			results[name] = err.Error()
+			ready = false
+		} else {
+			results[name] = "ok"
+		}
+	}
+	return results, ready
+}
+
+//checkBundles fails if the last LoadBundle of some group failed
+func checkBundles() error {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	failed := make([]string, 0)
+	for groupName := range bundleErrors {
+		failed = append(failed, groupName)
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("Last bundle load failed for groups %v", failed)
+	}
+	return nil
+}
+
+//Info returns the version, start time and rule sets of the registered groups
+func Info() BuildInfo {
+	return groupsInfo(func(string) bool { return true })
+}
+
+//groupsInfo returns the info with only the groups accepted by 'allowed'
+func groupsInfo(allowed func(groupName string) bool) BuildInfo {
+	info := BuildInfo{Version: Version, StartTime: startTime, Groups: make(map[string]GroupInfo)}
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	for _, groupName := range groupNames() {
+		if !allowed(groupName) {
+			continue
+		}
+		desc, err := describeGroup(groupName)
+		if err != nil {
+			continue
+		}
+		b, _ := json.Marshal(desc)
+		info.Groups[groupName] = GroupInfo{Rules: len(rulesMap[groupName]), Hash: fmt.Sprintf("%x", sha1.Sum(b))}
+	}
+	return info
+}
+
+//HandleHealth answers 200 while the process is alive (GET /healthz)
+func HandleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]string{"status": "ok"})
+}
+
+//HandleReady answers 200 if all readiness checks pass and 503 otherwise, with the result of each check (GET /readyz)
+func HandleReady(w http.ResponseWriter, r *http.Request) {
+	checks, ready := Readiness()
+	writeReadiness(w, checks, ready)
+}
+
+func writeReadiness(w http.ResponseWriter, checks map[string]string, ready bool) {
+	status := "ready"
+	if !ready {
+		status = "not ready"
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	writeJSON(w, map[string]interface{}{"status": status, "checks": checks})
+}
+
+//HandleInfo renders version, start time, group names, rule counts and rule set hashes of the groups the principal may access (GET /info)
+func HandleInfo(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, groupsInfo(groupAllowed(r)))
+}
@@ -0,0 +1,56 @@
+package ruller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthAndInfo(t *testing.T) {
+	w := httptest.NewRecorder()
+	HandleHealth(w, httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(t, 200, w.Code)
+
+	w = httptest.NewRecorder()
+	HandleReady(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, 200, w.Code)
+
+	available := false
+	AddReadinessCheck("cache", func() error {
+		if !available {
+			return fmt.Errorf("cache not connected")
+		}
+		return nil
+	})
+	w = httptest.NewRecorder()
+	HandleReady(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, 503, w.Code)
+	result := make(map[string]interface{})
+	json.Unmarshal(w.Body.Bytes(), &result)
+	assert.Equal(t, "cache not connected", result["checks"].(map[string]interface{})["cache"])
+	available = true
+
+	err := LoadBundle(&Bundle{Group: "health-bundle", Strategy: "unknown"})
+	assert.NotNil(t, err)
+	checks, ready := Readiness()
+	assert.False(t, ready)
+	assert.NotEqual(t, "ok", checks["bundles"])
+	err = LoadBundle(&Bundle{Group: "health-bundle"})
+	assert.Nil(t, err)
+	_, ready = Readiness()
+	assert.True(t, ready)
+
+	Add("health", "rule1", func(ctx Context) (map[string]interface{}, error) { return nil, nil })
+	AddChild("health", "rule1.1", "rule1", func(ctx Context) (map[string]interface{}, error) { return nil, nil })
+	info := Info()
+	assert.Equal(t, Version, info.Version)
+	assert.False(t, info.StartTime.IsZero())
+	assert.Equal(t, 2, info.Groups["health"].Rules)
+	hash := info.Groups["health"].Hash
+	assert.NotEmpty(t, hash)
+	SetRuleEnabled("health", "rule1.1", false, "test")
+	assert.NotEqual(t, hash, Info().Groups["health"].Hash)
+}
@@ -0,0 +1,158 @@
+package ruller
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+)
+
+//GroupDescription describes the rules of a group in their effective evaluation order
+type GroupDescription struct {
+	Name           string                       `json:"name"`
+	Strategy       string                       `json:"strategy"`
+	RequiredInputs map[string]string            `json:"requiredInputs"`
+	Outputs        map[string]OutputDescription `json:"outputs,omitempty"`
+	Rules          []RuleDescription            `json:"rules"`
+}
+
+//OutputDescription describes an output attribute declared with DeclareOutput
+type OutputDescription struct {
+	Type   string   `json:"type"`
+	Values []string `json:"values,omitempty"`
+}
+
+//RuleDescription describes a registered rule
+type RuleDescription struct {
+	Name             string            `json:"name"`
+	Priority         int               `json:"priority"`
+	OutputKeys       []string          `json:"outputKeys,omitempty"`
+	Enabled          bool              `json:"enabled"`
+	Declarative      bool              `json:"declarative"`
+	ServerOnly       bool              `json:"serverOnly"`
+	Dependencies     []string          `json:"dependencies,omitempty"`
+	ChildrenStrategy string            `json:"childrenStrategy,omitempty"`
+	Children         []RuleDescription `json:"children,omitempty"`
+}
+
+//String name of the input type
+func (it InputType) String() string {
+	switch it {
+	case String:
+		return "string"
+	case Float64:
+		return "numeric"
+	case Bool:
+		return "bool"
+	case Object:
+		return "object"
+	case Array:
+		return "array"
+	}
+	return fmt.Sprintf("InputType(%d)", int(it))
+}
+
+//String name of the evaluation strategy
+func (s EvaluationStrategy) String() string {
+	switch s {
+	case EvaluateAll:
+		return "all"
+	case EvaluateFirstMatch:
+		return "first-match"
+	}
+	return fmt.Sprintf("EvaluationStrategy(%d)", int(s))
+}
+
+//String name of the output validation mode
+func (v OutputValidation) String() string {
+	switch v {
+	case ValidationLog:
+		return "log"
+	case ValidationDrop:
+		return "drop"
+	case ValidationReject:
+		return "reject"
+	}
+	return fmt.Sprintf("OutputValidation(%d)", int(v))
+}
+
+//GroupNames returns the names of all registered groups, sorted
+func GroupNames() []string {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	return groupNames()
+}
+
+func groupNames() []string {
+	names := make([]string, 0, len(groupRules))
+	for groupName := range groupRules {
+		names = append(names, groupName)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//DescribeGroup returns the rules of a group in their effective evaluation order
+func DescribeGroup(groupName string) (GroupDescription, error) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	return describeGroup(groupName)
+}
+
+func describeGroup(groupName string) (GroupDescription, error) {
+	rules, exists := groupRules[groupName]
+	if !exists {
+		return GroupDescription{}, notFoundError{fmt.Errorf("Group %s doesn't exist", groupName)}
+	}
+	desc := GroupDescription{
+		Name:           groupName,
+		Strategy:       groupStrategy[groupName].String(),
+		RequiredInputs: make(map[string]string),
+		Rules:          describeRules(rules, false),
+	}
+	for inputName, it := range requiredInputNames[groupName] {
+		desc.RequiredInputs[inputName] = it.String()
+	}
+	for outputName, decl := range outputSchemas[groupName] {
+		if desc.Outputs == nil {
+			desc.Outputs = make(map[string]OutputDescription)
+		}
+		desc.Outputs[outputName] = OutputDescription{Type: decl.outputType.String(), Values: decl.values}
+	}
+	return desc, nil
+}
+
+//describeRules describes rules. Rules are server only if they are Go functions or if some ancestor is
+func describeRules(rules []*ruleInfo, serverOnly bool) []RuleDescription {
+	descs := make([]RuleDescription, 0, len(rules))
+	for _, rinfo := range rules {
+		rdesc := RuleDescription{
+			Name:        rinfo.name,
+			Priority:    rinfo.priority,
+			Enabled:     IsRuleEnabled(rinfo.groupName, rinfo.name),
+			Declarative: rinfo.declaration != nil,
+			ServerOnly:  serverOnly || rinfo.declaration == nil,
+			OutputKeys:  rinfo.outputKeys,
+		}
+		for _, dinfo := range rinfo.dependencies {
+			rdesc.Dependencies = append(rdesc.Dependencies, dinfo.name)
+		}
+		if len(rinfo.children) > 0 {
+			rdesc.ChildrenStrategy = rinfo.childrenStrategy.String()
+			rdesc.Children = describeRules(rinfo.children, rdesc.ServerOnly)
+		}
+		descs = append(descs, rdesc)
+	}
+	return descs
+}
+
+//HandleDescribeGroup renders the description of a group (GET /rules/{groupName})
+func HandleDescribeGroup(w http.ResponseWriter, r *http.Request) {
+	desc, err := DescribeGroup(mux.Vars(r)["groupName"])
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	writeJSON(w, desc)
+}
@@ -0,0 +1,202 @@
+package ruller
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//JWTAuthenticator authenticates requests with "Authorization: Bearer [JWT]" verified with the keys of a local JWKS file.
+//Supports RS256, RS384, RS512, ES256 and ES384. The "sub" claim is the principal name and all claims are available to rules in "_claims"
+type JWTAuthenticator struct {
+	//Keys public keys by key id ("kid")
+	Keys map[string]crypto.PublicKey
+	//Issuer if defined, the "iss" claim must match it
+	Issuer string
+	//Audience if defined, the "aud" claim must contain it
+	Audience string
+	//Leeway tolerance for clock differences when checking "exp" and "nbf"
+	Leeway time.Duration
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+var jwtAlgorithms = map[string]struct {
+	hash crypto.Hash
+	kty  string
+}{
+	"RS256": {crypto.SHA256, "RSA"},
+	"RS384": {crypto.SHA384, "RSA"},
+	"RS512": {crypto.SHA512, "RSA"},
+	"ES256": {crypto.SHA256, "EC"},
+	"ES384": {crypto.SHA384, "EC"},
+}
+
+//LoadJWKS creates a JWT authenticator with the RSA and EC keys of a JWKS file
+func LoadJWKS(file string) (*JWTAuthenticator, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	jwks := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	err = json.Unmarshal(data, &jwks)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid JWKS file %s. err=%s", file, err)
+	}
+	a := &JWTAuthenticator{Keys: make(map[string]crypto.PublicKey)}
+	for _, k := range jwks.Keys {
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("Invalid key '%s' in JWKS file %s. err=%s", k.Kid, file, err)
+		}
+		a.Keys[k.Kid] = key
+	}
+	return a, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+		e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("Invalid RSA modulus or exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("Unsupported curve %s", k.Crv)
+		}
+		x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+		y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("Invalid EC coordinates")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("Unsupported key type %s", k.Kty)
+}
+
+//Authenticate verifies the bearer token
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		return nil, nil
+	}
+	claims, err := a.Verify(strings.TrimPrefix(authorization, "Bearer "))
+	if err != nil {
+		return nil, err
+	}
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return nil, fmt.Errorf("Token without 'sub' claim")
+	}
+	return &Principal{Name: sub, Method: "jwt", Claims: claims}, nil
+}
+
+//Verify checks the signature and the time, issuer and audience claims of a token. Returns its claims
+func (a *JWTAuthenticator) Verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("Malformed token")
+	}
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	err := decodeJWTPart(parts[0], &header)
+	if err != nil {
+		return nil, err
+	}
+	alg, exists := jwtAlgorithms[header.Alg]
+	if !exists {
+		return nil, fmt.Errorf("Unsupported token algorithm '%s'", header.Alg)
+	}
+	key, exists := a.Keys[header.Kid]
+	if !exists {
+		return nil, fmt.Errorf("Unknown token key id '%s'", header.Kid)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("Malformed token signature")
+	}
+	h := alg.hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if alg.kty != "RSA" || rsa.VerifyPKCS1v15(k, alg.hash, digest, signature) != nil {
+			return nil, fmt.Errorf("Invalid token signature")
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if alg.kty != "EC" || len(signature) != 2*size {
+			return nil, fmt.Errorf("Invalid token signature")
+		}
+		rs := new(big.Int).SetBytes(signature[:size])
+		ss := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(k, digest, rs, ss) {
+			return nil, fmt.Errorf("Invalid token signature")
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported key for token key id '%s'", header.Kid)
+	}
+
+	claims := make(map[string]interface{})
+	err = decodeJWTPart(parts[1], &claims)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if exp, ok := toFloat64(claims["exp"]); ok && now.After(time.Unix(int64(exp), 0).Add(a.Leeway)) {
+		return nil, fmt.Errorf("Token expired")
+	}
+	if nbf, ok := toFloat64(claims["nbf"]); ok && now.Before(time.Unix(int64(nbf), 0).Add(-a.Leeway)) {
+		return nil, fmt.Errorf("Token not valid yet")
+	}
+	if a.Issuer != "" && claims["iss"] != a.Issuer {
+		return nil, fmt.Errorf("Invalid token issuer")
+	}
+	if a.Audience != "" && !containsValue(toArray(claims["aud"]), a.Audience) {
+		return nil, fmt.Errorf("Invalid token audience")
+	}
+	return claims, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return fmt.Errorf("Malformed token")
+	}
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		return fmt.Errorf("Malformed token")
+	}
+	return nil
+}
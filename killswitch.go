@@ -0,0 +1,220 @@
+package ruller
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+//RuleState runtime enablement state of a rule along with who changed it last
+type RuleState struct {
+	Enabled   bool      `json:"enabled"`
+	ChangedBy string    `json:"changedBy,omitempty"`
+	ChangedAt time.Time `json:"changedAt,omitempty"`
+}
+
+var (
+	ruleStates     = make(map[string]map[string]RuleState) //[group][rule]
+	ruleStateMutex sync.RWMutex
+	ruleStateFile  string
+)
+
+var ruleEnabledGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "ruller_rule_enabled",
+	Help: "Whatever a rule is enabled (1) or disabled by a kill switch (0)",
+}, []string{
+	"group",
+	"rule",
+})
+
+//SetRuleEnabled enables or disables a rule at runtime. Disabled rules and all their children are skipped during processing. 'who' is recorded for auditing.
+//If the state can't be persisted (see LoadRuleStates), the rule keeps its previous state
+func SetRuleEnabled(groupName string, ruleName string, enabled bool, who string) error {
+	registryMutex.RLock()
+	_, exists := rulesMap[groupName][ruleName]
+	registryMutex.RUnlock()
+	if !exists {
+		return notFoundError{fmt.Errorf("Rule '%s' not found in group '%s'", ruleName, groupName)}
+	}
+	err := setRuleState(groupName, ruleName, enabled, who)
+	if err != nil {
+		return err
+	}
+	//watchers re-evaluate the group, so they are notified only after the state lock is released
+	notifyGroupChanged(groupName)
+	return nil
+}
+
+func setRuleState(groupName string, ruleName string, enabled bool, who string) error {
+	ruleStateMutex.Lock()
+	defer ruleStateMutex.Unlock()
+	gs, exists := ruleStates[groupName]
+	if !exists {
+		gs = make(map[string]RuleState)
+		ruleStates[groupName] = gs
+	}
+	previous := ruleEnabledLocked(groupName, ruleName)
+	previousState, hadState := gs[ruleName]
+	gs[ruleName] = RuleState{Enabled: enabled, ChangedBy: who, ChangedAt: time.Now()}
+	err := saveRuleStatesLocked()
+	if err != nil {
+		if hadState {
+			gs[ruleName] = previousState
+		} else {
+			delete(gs, ruleName)
+		}
+		return err
+	}
+	setRuleEnabledGauge(groupName, ruleName, enabled)
+
+	logrus.WithFields(logrus.Fields{
+		"audit":    "rule-enabled",
+		"group":    groupName,
+		"rule":     ruleName,
+		"previous": previous,
+		"enabled":  enabled,
+		"who":      who,
+	}).Infof("Rule '%s' of group '%s' enabled=%t by '%s'", ruleName, groupName, enabled, who)
+	return nil
+}
+
+//IsRuleEnabled returns whatever a rule is currently enabled. Rules are enabled unless explicitly disabled
+func IsRuleEnabled(groupName string, ruleName string) bool {
+	ruleStateMutex.RLock()
+	defer ruleStateMutex.RUnlock()
+	return ruleEnabledLocked(groupName, ruleName)
+}
+
+//GetRuleState returns the current runtime state of a rule
+func GetRuleState(groupName string, ruleName string) (RuleState, error) {
+	registryMutex.RLock()
+	_, exists := rulesMap[groupName][ruleName]
+	registryMutex.RUnlock()
+	if !exists {
+		return RuleState{}, notFoundError{fmt.Errorf("Rule '%s' not found in group '%s'", ruleName, groupName)}
+	}
+	ruleStateMutex.RLock()
+	defer ruleStateMutex.RUnlock()
+	state, exists := ruleStates[groupName][ruleName]
+	if !exists {
+		return RuleState{Enabled: true}, nil
+	}
+	return state, nil
+}
+
+//LoadRuleStates restores rule states from a local file and keeps it updated on every change. A missing file is not an error
+func LoadRuleStates(file string) error {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	ruleStateMutex.Lock()
+	defer ruleStateMutex.Unlock()
+	ruleStateFile = file
+
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		logrus.Infof("Rule state file %s not found. All rules enabled", file)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	states := make(map[string]map[string]RuleState)
+	err = json.Unmarshal(data, &states)
+	if err != nil {
+		return fmt.Errorf("Invalid rule state file %s. err=%s", file, err)
+	}
+	for groupName, gs := range states {
+		for ruleName, state := range gs {
+			if _, exists := rulesMap[groupName][ruleName]; !exists {
+				logrus.Warnf("Ignoring state for unknown rule '%s' of group '%s'", ruleName, groupName)
+				delete(gs, ruleName)
+				continue
+			}
+			setRuleEnabledGauge(groupName, ruleName, state.Enabled)
+		}
+	}
+	ruleStates = states
+	logrus.Infof("Rule states loaded from %s", file)
+	return nil
+}
+
+func ruleEnabledLocked(groupName string, ruleName string) bool {
+	state, exists := ruleStates[groupName][ruleName]
+	return !exists || state.Enabled
+}
+
+func setRuleEnabledGauge(groupName string, ruleName string, enabled bool) {
+	v := 0.0
+	if enabled {
+		v = 1
+	}
+	ruleEnabledGauge.WithLabelValues(groupName, ruleName).Set(v)
+}
+
+func saveRuleStatesLocked() error {
+	if ruleStateFile == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(ruleStates, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpFile := ruleStateFile + ".tmp"
+	err = ioutil.WriteFile(tmpFile, data, 0644)
+	if err != nil {
+		return fmt.Errorf("Couldn't persist rule states. err=%s", err)
+	}
+	return os.Rename(tmpFile, ruleStateFile)
+}
+
+//requestPrincipal identifies who is performing an admin request for audit purposes, along with the remote address.
+//Uses the authenticated principal or, if authentication is disabled, the header "X-Ruller-User", marked as unverified as any client may send it
+func requestPrincipal(r *http.Request) string {
+	if p := RequestPrincipal(r); p != nil {
+		return fmt.Sprintf("%s(%s)@%s", p.Name, p.Method, r.RemoteAddr)
+	}
+	user := r.Header.Get("X-Ruller-User")
+	if user == "" {
+		return fmt.Sprintf("anonymous@%s", r.RemoteAddr)
+	}
+	return fmt.Sprintf("%s(unverified)@%s", user, r.RemoteAddr)
+}
+
+//HandleRuleEnabled gets (GET) or changes (PUT with body {"enabled": bool}) the runtime state of a rule
+func HandleRuleEnabled(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	groupName := params["groupName"]
+	ruleName := params["ruleName"]
+
+	if r.Method == http.MethodPut {
+		body := struct {
+			Enabled *bool `json:"enabled"`
+		}{}
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil || body.Enabled == nil {
+			http.Error(w, "Body must be in format {\"enabled\": true|false}", 400)
+			return
+		}
+		err = SetRuleEnabled(groupName, ruleName, *body.Enabled, requestPrincipal(r))
+		if err != nil {
+			logrus.Warnf("Error changing rule state. err=%s", err)
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+	}
+
+	state, err := GetRuleState(groupName, ruleName)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	writeJSON(w, state)
+}
@@ -0,0 +1,78 @@
+package ruller
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRuleKillSwitch(t *testing.T) {
+	Add("killswitch", "rule1", func(ctx Context) (map[string]interface{}, error) {
+		return map[string]interface{}{"rule1": true}, nil
+	})
+	Add("killswitch", "rule2", func(ctx Context) (map[string]interface{}, error) {
+		return map[string]interface{}{"rule2": true}, nil
+	})
+	AddChild("killswitch", "rule2.1", "rule2", func(ctx Context) (map[string]interface{}, error) {
+		return map[string]interface{}{"rule2.1": true}, nil
+	})
+
+	dir, err := ioutil.TempDir("", "ruller")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	stateFile := filepath.Join(dir, "states.json")
+	assert.Nil(t, LoadRuleStates(stateFile))
+	defer func() { ruleStateFile = "" }()
+
+	options := ProcessOptions{FlattenOutput: true, MergeKeepFirst: true}
+	output, err := Process("killswitch", map[string]interface{}{}, options)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"rule1": true, "rule2": true, "rule2.1": true}, output)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/admin/rules/{groupName}/{ruleName}/enabled", HandleRuleEnabled)
+	r, _ := http.NewRequest("PUT", "/admin/rules/killswitch/rule2/enabled", bytes.NewBufferString(`{"enabled":false}`))
+	r.Header.Set("X-Ruller-User", "tester")
+	r.RemoteAddr = "10.0.0.1:1234"
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.False(t, IsRuleEnabled("killswitch", "rule2"))
+
+	output, err = Process("killswitch", map[string]interface{}{}, options)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"rule1": true}, output)
+
+	state, err := GetRuleState("killswitch", "rule2")
+	assert.Nil(t, err)
+	assert.Equal(t, "tester(unverified)@10.0.0.1:1234", state.ChangedBy)
+
+	ruleStates = make(map[string]map[string]RuleState)
+	assert.Nil(t, LoadRuleStates(stateFile))
+	assert.False(t, IsRuleEnabled("killswitch", "rule2"))
+
+	assert.Nil(t, SetRuleEnabled("killswitch", "rule2", true, "tester"))
+	assert.NotNil(t, SetRuleEnabled("killswitch", "unknown", true, "tester"))
+	output, err = Process("killswitch", map[string]interface{}{}, options)
+	assert.Nil(t, err)
+	assert.Len(t, output, 3)
+
+	r, _ = http.NewRequest("PUT", "/admin/rules/killswitch/unknown/enabled", bytes.NewBufferString(`{"enabled":false}`))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	ruleStateFile = filepath.Join(dir, "missing", "states.json")
+	r, _ = http.NewRequest("PUT", "/admin/rules/killswitch/rule1/enabled", bytes.NewBufferString(`{"enabled":false}`))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.True(t, IsRuleEnabled("killswitch", "rule1"), "state must not change when it can't be persisted")
+}
@@ -0,0 +1,245 @@
+package ruller
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+//RateLimit token bucket limit of the evaluations of a group by each client
+type RateLimit struct {
+	//Key identifies clients: "ip" (client IP), "api-key" (header X-API-Key), "principal" (authenticated principal) or "input:[attribute]" (an input attribute, such as "input:userId").
+	//Requests without a value for the key share the same bucket
+	Key string
+	//Rate tokens added to each bucket per second
+	Rate float64
+	//Burst bucket size. Defaults to Rate
+	Burst int
+}
+
+type tokenBucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+//bucketCache token buckets by client key. The least recently used bucket is evicted when full
+type bucketCache struct {
+	items map[string]*list.Element
+	lru   *list.List
+}
+
+func newBucketCache() *bucketCache {
+	return &bucketCache{items: make(map[string]*list.Element), lru: list.New()}
+}
+
+type groupLimiter struct {
+	rate    *RateLimit
+	buckets *bucketCache
+	//slots available evaluation slots. nil if concurrency is not limited
+	slots chan struct{}
+}
+
+//maxBuckets number of buckets kept per group. Evicted buckets start full again when their clients return
+var maxBuckets = 10000
+
+var (
+	groupLimiters = make(map[string]*groupLimiter)
+	limitersMutex sync.Mutex
+)
+
+var limiterDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "ruller_limiter_decisions_total",
+	Help: "Admission decisions of rate and concurrency limiters",
+}, []string{
+	"group",
+	"limiter",
+	"decision",
+})
+
+//limitError returned when a request is rejected by a limiter
+type limitError struct {
+	error
+	retryAfter time.Duration
+}
+
+//SetRateLimit limits the evaluations of a group by each client. Use "*" as group name for the default of groups without their own limit
+func SetRateLimit(groupName string, limit RateLimit) error {
+	if limit.Key != "ip" && limit.Key != "api-key" && limit.Key != "principal" && !strings.HasPrefix(limit.Key, "input:") {
+		return fmt.Errorf("Invalid rate limit key '%s'", limit.Key)
+	}
+	if limit.Rate <= 0 {
+		return fmt.Errorf("Rate limit must be positive")
+	}
+	if limit.Burst <= 0 {
+		limit.Burst = int(math.Ceil(limit.Rate))
+	}
+	limitersMutex.Lock()
+	defer limitersMutex.Unlock()
+	l := limiterLocked(groupName)
+	l.rate = &limit
+	l.buckets = newBucketCache()
+	return nil
+}
+
+//SetConcurrencyLimit limits the number of concurrent evaluations of a group. Use "*" as group name for the default of groups without their own limit.
+//Each group has its own slots, even when using the default. Use 0 to remove the limit of a group (the default, if any, still applies)
+func SetConcurrencyLimit(groupName string, max int) {
+	limitersMutex.Lock()
+	defer limitersMutex.Unlock()
+	l := limiterLocked(groupName)
+	l.slots = nil
+	if max > 0 {
+		l.slots = make(chan struct{}, max)
+	}
+}
+
+func limiterLocked(groupName string) *groupLimiter {
+	l, exists := groupLimiters[groupName]
+	if !exists {
+		l = &groupLimiter{buckets: newBucketCache()}
+		groupLimiters[groupName] = l
+	}
+	return l
+}
+
+//admitRequest applies the concurrency limit and the rate limit of a group when the rate limit key doesn't depend on input ("ip", "api-key" or "principal").
+//It is called before input enrichment, so that rejected requests cost as little as possible. On success, returns a function that must be called when the evaluation finishes
+func admitRequest(r *http.Request, groupName string) (func(), error) {
+	limitersMutex.Lock()
+	l := groupLimiters[groupName]
+	def := groupLimiters["*"]
+	rate, buckets := rateLimiterLocked(groupName)
+	if rate != nil && !strings.HasPrefix(rate.Key, "input:") {
+		err := takeGroupToken(groupName, buckets, rateLimitKey(r, rate.Key, nil), rate)
+		if err != nil {
+			limitersMutex.Unlock()
+			return nil, err
+		}
+	}
+
+	if (l == nil || l.slots == nil) && def != nil && def.slots != nil {
+		l = limiterLocked(groupName)
+		l.slots = make(chan struct{}, cap(def.slots))
+	}
+	var slots chan struct{}
+	if l != nil {
+		slots = l.slots
+	}
+	limitersMutex.Unlock()
+
+	if slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case slots <- struct{}{}:
+		limiterDecisions.WithLabelValues(groupName, "concurrency", "allowed").Inc()
+		return func() { <-slots }, nil
+	default:
+		limiterDecisions.WithLabelValues(groupName, "concurrency", "rejected").Inc()
+		logrus.Debugf("Concurrency limit of group %s reached", groupName)
+		return nil, limitError{fmt.Errorf("Too many concurrent evaluations"), time.Second}
+	}
+}
+
+//admitInput applies the rate limit of a group when its key is an input attribute ("input:[attribute]").
+//It is called after input enrichment and the request filter, as they may provide the attribute
+func admitInput(r *http.Request, groupName string, input map[string]interface{}) error {
+	limitersMutex.Lock()
+	defer limitersMutex.Unlock()
+	rate, buckets := rateLimiterLocked(groupName)
+	if rate == nil || !strings.HasPrefix(rate.Key, "input:") {
+		return nil
+	}
+	return takeGroupToken(groupName, buckets, rateLimitKey(r, rate.Key, input), rate)
+}
+
+//rateLimiterLocked returns the rate limit of a group (or the default one) along with the buckets of the group. Default limits are enforced per group
+func rateLimiterLocked(groupName string) (*RateLimit, *bucketCache) {
+	l := groupLimiters[groupName]
+	if l != nil && l.rate != nil {
+		return l.rate, l.buckets
+	}
+	def := groupLimiters["*"]
+	if def != nil && def.rate != nil {
+		return def.rate, limiterLocked(groupName).buckets
+	}
+	return nil, nil
+}
+
+//takeGroupToken takes a token for a key, returning a limitError if the rate limit is exceeded
+func takeGroupToken(groupName string, buckets *bucketCache, key string, rate *RateLimit) error {
+	retryAfter := takeToken(buckets, key, rate)
+	if retryAfter > 0 {
+		limiterDecisions.WithLabelValues(groupName, "rate", "rejected").Inc()
+		logrus.Debugf("Rate limit of group %s exceeded by '%s'", groupName, key)
+		return limitError{fmt.Errorf("Rate limit exceeded"), retryAfter}
+	}
+	limiterDecisions.WithLabelValues(groupName, "rate", "allowed").Inc()
+	return nil
+}
+
+//takeToken takes a token from the bucket of a key. Returns how long to wait for a token if the bucket is empty
+func takeToken(buckets *bucketCache, key string, rate *RateLimit) time.Duration {
+	now := time.Now()
+	var b *tokenBucket
+	if e, exists := buckets.items[key]; exists {
+		buckets.lru.MoveToFront(e)
+		b = e.Value.(*tokenBucket)
+	} else {
+		if buckets.lru.Len() >= maxBuckets {
+			oldest := buckets.lru.Back()
+			buckets.lru.Remove(oldest)
+			delete(buckets.items, oldest.Value.(*tokenBucket).key)
+		}
+		b = &tokenBucket{key: key, tokens: float64(rate.Burst), last: now}
+		buckets.items[key] = buckets.lru.PushFront(b)
+	}
+	b.tokens = math.Min(float64(rate.Burst), b.tokens+now.Sub(b.last).Seconds()*rate.Rate)
+	b.last = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / rate.Rate * float64(time.Second))
+	}
+	b.tokens--
+	return 0
+}
+
+func rateLimitKey(r *http.Request, key string, input map[string]interface{}) string {
+	switch key {
+	case "ip":
+		ip, _ := clientIP(r)
+		if ip == "" {
+			return "0.0.0.0"
+		}
+		return ip
+	case "api-key":
+		return r.Header.Get("X-API-Key")
+	case "principal":
+		if p := RequestPrincipal(r); p != nil {
+			return p.Name
+		}
+		return ""
+	}
+	v, exists := input[strings.TrimPrefix(key, "input:")]
+	if !exists || v == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+//retryAfterSeconds value of the Retry-After header for a limit error
+func retryAfterSeconds(err error) (string, bool) {
+	le, ok := err.(limitError)
+	if !ok {
+		return "", false
+	}
+	return strconv.Itoa(int(math.Ceil(le.retryAfter.Seconds()))), true
+}
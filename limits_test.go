@@ -0,0 +1,129 @@
+package ruller
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimit(t *testing.T) {
+	Add("ratelimit", "rule1", func(ctx Context) (map[string]interface{}, error) {
+		return map[string]interface{}{"rule1": true}, nil
+	})
+	assert.NotNil(t, SetRateLimit("ratelimit", RateLimit{Key: "cookie", Rate: 1}))
+	assert.Nil(t, SetRateLimit("ratelimit", RateLimit{Key: "input:userId", Rate: 0.5, Burst: 2}))
+	defer delete(groupLimiters, "ratelimit")
+
+	router := mux.NewRouter()
+	router.HandleFunc("/rules/{groupName}", HandleRuleGroup).Methods("POST")
+	post := func(body string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("POST", "/rules/ratelimit", bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, post(`{"userId":"a"}`).Code)
+	assert.Equal(t, http.StatusOK, post(`{"userId":"a"}`).Code)
+	w := post(`{"userId":"a"}`)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+
+	//other clients have their own buckets
+	assert.Equal(t, http.StatusOK, post(`{"userId":"b"}`).Code)
+}
+
+func TestRateLimitBeforeEnrichment(t *testing.T) {
+	Add("ratelimit-ip", "rule1", func(ctx Context) (map[string]interface{}, error) {
+		return map[string]interface{}{"rule1": true}, nil
+	})
+	enriched := 0
+	AddEnricher("ratelimit-ip", EnricherFunc(func(ctx context.Context, r *http.Request, input map[string]interface{}) error {
+		enriched++
+		return nil
+	}), "enriched")
+	assert.Nil(t, SetRateLimit("ratelimit-ip", RateLimit{Key: "ip", Rate: 0.001, Burst: 1}))
+	defer delete(groupLimiters, "ratelimit-ip")
+
+	evaluate := func() int {
+		r, _ := http.NewRequest("POST", "/rules/ratelimit-ip", bytes.NewBufferString(`{}`))
+		r.RemoteAddr = "10.0.0.1:1234"
+		r = mux.SetURLVars(r, map[string]string{"groupName": "ratelimit-ip"})
+		w := httptest.NewRecorder()
+		HandleRuleGroup(w, r)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, evaluate())
+	assert.Equal(t, http.StatusTooManyRequests, evaluate())
+	assert.Equal(t, 1, enriched, "rejected requests must not be enriched")
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	started := make(chan bool)
+	finish := make(chan bool)
+	Add("concurrency", "rule1", func(ctx Context) (map[string]interface{}, error) {
+		if ctx.Input["block"] == true {
+			started <- true
+			<-finish
+		}
+		return map[string]interface{}{"rule1": true}, nil
+	})
+	SetConcurrencyLimit("concurrency", 1)
+	defer delete(groupLimiters, "concurrency")
+
+	evaluate := func(body string) int {
+		r, _ := http.NewRequest("POST", "/rules/concurrency", bytes.NewBufferString(body))
+		r = mux.SetURLVars(r, map[string]string{"groupName": "concurrency"})
+		w := httptest.NewRecorder()
+		HandleRuleGroup(w, r)
+		return w.Code
+	}
+
+	done := make(chan int)
+	go func() { done <- evaluate(`{"block":true}`) }()
+	<-started
+	assert.Equal(t, http.StatusTooManyRequests, evaluate(`{}`))
+	close(finish)
+	assert.Equal(t, http.StatusOK, <-done)
+	assert.Equal(t, http.StatusOK, evaluate(`{}`))
+}
+
+func TestLimitersOfUnknownGroups(t *testing.T) {
+	assert.Nil(t, SetRateLimit("*", RateLimit{Key: "ip", Rate: 100}))
+	defer delete(groupLimiters, "*")
+
+	for i := 0; i < 5; i++ {
+		groupName := fmt.Sprintf("made-up-%d", i)
+		r, _ := http.NewRequest("POST", "/rules/"+groupName, bytes.NewBufferString(`{}`))
+		r = mux.SetURLVars(r, map[string]string{"groupName": groupName})
+		w := httptest.NewRecorder()
+		HandleRuleGroup(w, r)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		_, exists := groupLimiters[groupName]
+		assert.False(t, exists)
+	}
+}
+
+func TestRateLimitBucketsEviction(t *testing.T) {
+	defer func(max int) { maxBuckets = max }(maxBuckets)
+	maxBuckets = 2
+	rate := &RateLimit{Key: "ip", Rate: 0.001, Burst: 1}
+	buckets := newBucketCache()
+
+	assert.Equal(t, time.Duration(0), takeToken(buckets, "a", rate))
+	assert.Equal(t, time.Duration(0), takeToken(buckets, "b", rate))
+	assert.NotEqual(t, time.Duration(0), takeToken(buckets, "a", rate))
+	//"b" is the least recently used
+	assert.Equal(t, time.Duration(0), takeToken(buckets, "c", rate))
+	assert.Equal(t, 2, len(buckets.items))
+	_, exists := buckets.items["b"]
+	assert.False(t, exists)
+	assert.NotEqual(t, time.Duration(0), takeToken(buckets, "a", rate))
+}
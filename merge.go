@@ -0,0 +1,255 @@
+package ruller
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+//MergeStrategy decides the resulting value when more than one rule outputs the same key in flatten mode.
+//'existing' is the value already in output and 'incoming' the value from the rule being merged
+type MergeStrategy interface {
+	Merge(key string, existing interface{}, incoming interface{}) (interface{}, error)
+}
+
+//MergeStrategyFunc adapter to use ordinary functions as merge strategies
+type MergeStrategyFunc func(key string, existing interface{}, incoming interface{}) (interface{}, error)
+
+//Merge calls f(key, existing, incoming)
+func (f MergeStrategyFunc) Merge(key string, existing interface{}, incoming interface{}) (interface{}, error) {
+	return f(key, existing, incoming)
+}
+
+//MergeConflict a key output by more than one rule with different values. Reported in "_explain"
+type MergeConflict struct {
+	Key     string   `json:"key"`
+	Sources []string `json:"sources"`
+}
+
+//namedMergeStrategy a built-in merge strategy. Its name is used to export it in bundles
+type namedMergeStrategy struct {
+	name  string
+	merge MergeStrategyFunc
+}
+
+//Merge calls the strategy function
+func (s *namedMergeStrategy) Merge(key string, existing interface{}, incoming interface{}) (interface{}, error) {
+	return s.merge(key, existing, incoming)
+}
+
+var (
+	//KeepFirst keeps the value from the first rule processed. This is the default
+	KeepFirst MergeStrategy = &namedMergeStrategy{"keepFirst", func(key string, existing interface{}, incoming interface{}) (interface{}, error) {
+		return existing, nil
+	}}
+	//KeepLast keeps the value from the last rule processed
+	KeepLast MergeStrategy = &namedMergeStrategy{"keepLast", func(key string, existing interface{}, incoming interface{}) (interface{}, error) {
+		return incoming, nil
+	}}
+	//DeepMerge merges nested maps recursively. On conflicting values that are not maps, the first value is kept
+	DeepMerge MergeStrategy = &namedMergeStrategy{"deepMerge", func(key string, existing interface{}, incoming interface{}) (interface{}, error) {
+		return deepMerge(existing, incoming), nil
+	}}
+	//AppendToArray combines all values in an array. Array values are concatenated
+	AppendToArray MergeStrategy = &namedMergeStrategy{"appendToArray", func(key string, existing interface{}, incoming interface{}) (interface{}, error) {
+		return append(toArray(existing), toArray(incoming)...), nil
+	}}
+	//SetUnion combines all values in an array without duplicates
+	SetUnion MergeStrategy = &namedMergeStrategy{"setUnion", func(key string, existing interface{}, incoming interface{}) (interface{}, error) {
+		result := toArray(existing)
+		for _, v := range toArray(incoming) {
+			if !containsValue(result, v) {
+				result = append(result, v)
+			}
+		}
+		return result, nil
+	}}
+	//SumValues sums numeric values
+	SumValues MergeStrategy = &namedMergeStrategy{"sum", numericMerge(func(a float64, b float64) float64 { return a + b })}
+	//MaxValue keeps the greatest numeric value
+	MaxValue MergeStrategy = &namedMergeStrategy{"max", numericMerge(func(a float64, b float64) float64 {
+		if b > a {
+			return b
+		}
+		return a
+	})}
+	//MinValue keeps the smallest numeric value
+	MinValue MergeStrategy = &namedMergeStrategy{"min", numericMerge(func(a float64, b float64) float64 {
+		if b < a {
+			return b
+		}
+		return a
+	})}
+	//ErrorOnConflict fails processing if rules output different values for the same key
+	ErrorOnConflict MergeStrategy = &namedMergeStrategy{"errorOnConflict", func(key string, existing interface{}, incoming interface{}) (interface{}, error) {
+		if !reflect.DeepEqual(existing, incoming) {
+			return nil, fmt.Errorf("Conflicting values for output attribute '%s': %v and %v", key, existing, incoming)
+		}
+		return existing, nil
+	}}
+)
+
+var namedMergeStrategies = []MergeStrategy{KeepFirst, KeepLast, DeepMerge, AppendToArray, SetUnion, SumValues, MaxValue, MinValue, ErrorOnConflict}
+
+//mergeStrategyName name of a built-in merge strategy. Custom strategies have no name and can't be exported
+func mergeStrategyName(strategy MergeStrategy) (string, error) {
+	if s, ok := strategy.(*namedMergeStrategy); ok {
+		return s.name, nil
+	}
+	return "", fmt.Errorf("Custom merge strategies can't be exported")
+}
+
+func parseMergeStrategy(name string) (MergeStrategy, error) {
+	for _, strategy := range namedMergeStrategies {
+		if strategy.(*namedMergeStrategy).name == name {
+			return strategy, nil
+		}
+	}
+	return nil, fmt.Errorf("Invalid merge strategy '%s'", name)
+}
+
+var (
+	groupMergeStrategy = make(map[string]MergeStrategy)
+	keyMergeStrategy   = make(map[string]map[string]MergeStrategy) //[group][key]
+)
+
+//SetMergeStrategy sets the strategy used to merge duplicate keys of a group in flatten mode. If not set, "_keepFirst" determines whatever KeepFirst or KeepLast is used
+func SetMergeStrategy(groupName string, strategy MergeStrategy) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	groupMergeStrategy[groupName] = strategy
+}
+
+//SetKeyMergeStrategy sets the strategy used to merge a specific output key of a group. Has precedence over the group strategy
+func SetKeyMergeStrategy(groupName string, key string, strategy MergeStrategy) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	ks, exists := keyMergeStrategy[groupName]
+	if !exists {
+		ks = make(map[string]MergeStrategy)
+		keyMergeStrategy[groupName] = ks
+	}
+	ks[key] = strategy
+}
+
+//mergeStrategy returns the strategy for a key and whatever it was explicitly configured for the group.
+//Reserved keys (see isReservedKey) always use "_keepFirst", as they are added by ruller and not by rules
+func (e *evaluation) mergeStrategy(key string) (MergeStrategy, bool) {
+	if isReservedKey(key) {
+		if e.options.MergeKeepFirst {
+			return KeepFirst, false
+		}
+		return KeepLast, false
+	}
+	if s, exists := keyMergeStrategy[e.groupName][key]; exists {
+		return s, true
+	}
+	if s, exists := groupMergeStrategy[e.groupName]; exists {
+		return s, true
+	}
+	if e.options.MergeKeepFirst {
+		return KeepFirst, false
+	}
+	return KeepLast, false
+}
+
+//reservedKeys keys added to outputs by ruller itself
+var reservedKeys = map[string]bool{
+	"_rule":    true,
+	"_items":   true,
+	"_explain": true,
+	"_output":  true,
+	"children": true,
+}
+
+//isReservedKey keys such as "_rule" added to outputs by ruller. They are not subject to merge strategies nor reported as conflicts.
+//Other keys returned by rules, even if starting with "_", are merged as any other key
+func isReservedKey(key string) bool {
+	return reservedKeys[key]
+}
+
+func numericMerge(op func(a float64, b float64) float64) MergeStrategyFunc {
+	return MergeStrategyFunc(func(key string, existing interface{}, incoming interface{}) (interface{}, error) {
+		a, ok1 := toFloat64(existing)
+		b, ok2 := toFloat64(incoming)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("Output attribute '%s' must be numeric to be merged. values: %v and %v", key, existing, incoming)
+		}
+		return op(a, b), nil
+	})
+}
+
+func toFloat64(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func toArray(v interface{}) []interface{} {
+	if arr, ok := v.([]interface{}); ok {
+		return append([]interface{}{}, arr...)
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Slice {
+		arr := make([]interface{}, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			arr[i] = rv.Index(i).Interface()
+		}
+		return arr
+	}
+	return []interface{}{v}
+}
+
+func containsValue(arr []interface{}, v interface{}) bool {
+	for _, a := range arr {
+		if reflect.DeepEqual(a, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func deepMerge(existing interface{}, incoming interface{}) interface{} {
+	em, ok1 := existing.(map[string]interface{})
+	im, ok2 := incoming.(map[string]interface{})
+	if !ok1 || !ok2 {
+		return existing
+	}
+	result := copyMap(em)
+	for k, iv := range im {
+		ev, exists := result[k]
+		if exists {
+			result[k] = deepMerge(ev, iv)
+		} else {
+			result[k] = iv
+		}
+	}
+	return result
+}
@@ -0,0 +1,79 @@
+package ruller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeStrategies(t *testing.T) {
+	Add("merge", "rule1", func(ctx Context) (map[string]interface{}, error) {
+		return map[string]interface{}{
+			"menu":  map[string]interface{}{"home": true, "title": "first"},
+			"score": 2.0,
+			"tags":  "a",
+			"theme": "light",
+		}, nil
+	})
+	Add("merge", "rule2", func(ctx Context) (map[string]interface{}, error) {
+		return map[string]interface{}{
+			"menu":  map[string]interface{}{"settings": true, "title": "second"},
+			"score": 3.0,
+			"tags":  []interface{}{"a", "b"},
+			"theme": "dark",
+		}, nil
+	})
+	SetMergeStrategy("merge", DeepMerge)
+	SetKeyMergeStrategy("merge", "score", SumValues)
+	SetKeyMergeStrategy("merge", "tags", SetUnion)
+
+	options := ProcessOptions{FlattenOutput: true, MergeKeepFirst: true, Explain: true}
+	output, err := Process("merge", map[string]interface{}{}, options)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"home": true, "settings": true, "title": "first"}, output["menu"])
+	assert.Equal(t, 5.0, output["score"])
+	assert.Equal(t, []interface{}{"a", "b"}, output["tags"])
+	assert.Equal(t, "light", output["theme"])
+	conflicts := output["_explain"].(map[string]interface{})["conflicts"].([]MergeConflict)
+	assert.Len(t, conflicts, 4)
+
+	SetKeyMergeStrategy("merge", "theme", ErrorOnConflict)
+	_, err = Process("merge", map[string]interface{}{}, options)
+	assert.NotNil(t, err)
+
+	v, err := MaxValue.Merge("k", 1, 2.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 2.5, v)
+	_, err = MinValue.Merge("k", "a", 2.5)
+	assert.NotNil(t, err)
+	v, _ = AppendToArray.Merge("k", "a", "a")
+	assert.Equal(t, []interface{}{"a", "a"}, v)
+}
+
+func TestMergeStrategiesSkipReservedKeys(t *testing.T) {
+	Add("merge-reserved", "a", func(ctx Context) (map[string]interface{}, error) {
+		return map[string]interface{}{"a": true}, nil
+	})
+	Add("merge-reserved", "b", func(ctx Context) (map[string]interface{}, error) {
+		return map[string]interface{}{"b": true}, nil
+	})
+	SetMergeStrategy("merge-reserved", ErrorOnConflict)
+
+	options := ProcessOptions{FlattenOutput: true, AddRuleInfo: true, MergeKeepFirst: true, Explain: true}
+	output, err := Process("merge-reserved", map[string]interface{}{}, options)
+	assert.Nil(t, err)
+	assert.Equal(t, "a", output["_rule"])
+	assert.Len(t, output["_explain"].(map[string]interface{})["conflicts"], 0)
+
+	//keys not added by ruller follow merge strategies even if starting with "_"
+	Add("merge-underscore", "a", func(ctx Context) (map[string]interface{}, error) {
+		return map[string]interface{}{"_score": int8(1)}, nil
+	})
+	Add("merge-underscore", "b", func(ctx Context) (map[string]interface{}, error) {
+		return map[string]interface{}{"_score": uint16(2)}, nil
+	})
+	SetKeyMergeStrategy("merge-underscore", "_score", SumValues)
+	output, err = Process("merge-underscore", map[string]interface{}{}, ProcessOptions{FlattenOutput: true})
+	assert.Nil(t, err)
+	assert.Equal(t, 3.0, output["_score"])
+}
@@ -0,0 +1,193 @@
+package ruller
+
+import (
+	"net/http"
+	"sort"
+)
+
+//openAPITypes OpenAPI schema types of input types
+var openAPITypes = map[InputType]string{
+	String:  "string",
+	Float64: "number",
+	Bool:    "boolean",
+	Object:  "object",
+	Array:   "array",
+}
+
+//specialParameters special input attributes that change how rules are processed. See processOptions
+var specialParameters = map[string]map[string]interface{}{
+	"_flatten": {
+		"type":        "boolean",
+		"description": "Merge the outputs of all rules into a single flat map. When false, rule outputs are appended to anonymous '_items' arrays (or nested in rule nodes with '_treeOutput')",
+	},
+	"_keepFirst": {
+		"type":        "boolean",
+		"description": "When merging flat outputs, keep the value of the first rule that output an attribute instead of the last one",
+	},
+	"_info": {
+		"type":        "boolean",
+		"description": "Add the name of the rule in '_rule': of the last rule merged into the flat output or, with '_treeOutput', of each rule node",
+		"default":     true,
+	},
+	"_explain": {
+		"type":        "boolean",
+		"description": "Add '_explain' to the output with the source of each output attribute and merge conflicts",
+		"default":     false,
+	},
+	"_treeOutput": {
+		"type":        "boolean",
+		"description": "When not flatten, output one node per rule name with its output and children instead of anonymous '_items' arrays",
+		"default":     false,
+	},
+	"_fields": {
+		"description": "Output attributes to be returned. Nested attributes may be selected with dotted paths",
+		"oneOf": []interface{}{
+			map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+			map[string]interface{}{"type": "string", "description": "comma separated list"},
+		},
+	},
+}
+
+//OpenAPISpec generates an OpenAPI 3 specification for the REST API of all registered groups, with their declared inputs and outputs
+func OpenAPISpec() map[string]interface{} {
+	return openAPISpec(func(string) bool { return true })
+}
+
+//openAPISpec generates the specification with only the groups accepted by 'allowed'
+func openAPISpec(allowed func(groupName string) bool) map[string]interface{} {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	paths := make(map[string]interface{})
+	for _, groupName := range groupNames() {
+		if !allowed(groupName) {
+			continue
+		}
+		paths["/rules/"+groupName] = groupPathItem(groupName)
+	}
+	tooManyRequests := errorResponse("Rate or concurrency limit exceeded")
+	tooManyRequests["headers"] = map[string]interface{}{
+		"Retry-After": map[string]interface{}{"description": "Seconds to wait before retrying", "schema": map[string]interface{}{"type": "integer"}},
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "ruller",
+			"version": "1.0.0",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"responses": map[string]interface{}{
+				"BadRequest":           errorResponse("Invalid input (missing or mistyped required attributes, invalid special parameters or rejected output)"),
+				"Unauthorized":         errorResponse("Missing or invalid credentials (when authentication is enabled)"),
+				"Forbidden":            errorResponse("Credentials not allowed to access the group"),
+				"NotFound":             errorResponse("Group doesn't exist"),
+				"NotAcceptable":        errorResponse("None of the media types in Accept is supported"),
+				"PayloadTooLarge":      errorResponse("Request body larger than the input limits"),
+				"UnsupportedMediaType": errorResponse("Content-Type of the body is not supported"),
+				"TooManyRequests":      tooManyRequests,
+				"InternalError":        errorResponse("Error processing rules"),
+			},
+		},
+	}
+}
+
+func groupPathItem(groupName string) map[string]interface{} {
+	inputProperties := make(map[string]interface{})
+	required := make([]string, 0)
+	for inputName, it := range requiredInputNames[groupName] {
+		inputProperties[inputName] = map[string]interface{}{"type": openAPITypes[it]}
+		required = append(required, inputName)
+	}
+	sort.Strings(required)
+	for name, schema := range specialParameters {
+		inputProperties[name] = schema
+	}
+	inputSchema := map[string]interface{}{
+		"type":                 "object",
+		"properties":           inputProperties,
+		"additionalProperties": true,
+	}
+	if len(required) > 0 {
+		inputSchema["required"] = required
+	}
+	bodyContent := make(map[string]interface{})
+	for _, mediaType := range sortedDecoderMediaTypes() {
+		bodyContent[mediaType] = map[string]interface{}{"schema": inputSchema}
+	}
+
+	outputSchema := map[string]interface{}{
+		"type":                 "object",
+		"additionalProperties": true,
+	}
+	if len(outputSchemas[groupName]) > 0 {
+		outputProperties := make(map[string]interface{})
+		for outputName, decl := range outputSchemas[groupName] {
+			property := map[string]interface{}{"type": openAPITypes[decl.outputType]}
+			if len(decl.values) > 0 {
+				property["enum"] = decl.values
+			}
+			outputProperties[outputName] = property
+		}
+		outputSchema["properties"] = outputProperties
+	}
+	responseContent := make(map[string]interface{})
+	for _, mediaType := range sortedMediaTypes() {
+		responseContent[mediaType] = map[string]interface{}{"schema": outputSchema}
+	}
+
+	return map[string]interface{}{
+		"post": map[string]interface{}{
+			"summary":     "Process the rules of group '" + groupName + "'",
+			"operationId": "evaluate_" + groupName,
+			"requestBody": map[string]interface{}{
+				"required": false,
+				"content":  bodyContent,
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "Merged output of the rules",
+					"content":     responseContent,
+				},
+				"304": map[string]interface{}{"description": "Output didn't change (If-None-Match)"},
+				"400": map[string]interface{}{"$ref": "#/components/responses/BadRequest"},
+				"401": map[string]interface{}{"$ref": "#/components/responses/Unauthorized"},
+				"403": map[string]interface{}{"$ref": "#/components/responses/Forbidden"},
+				"404": map[string]interface{}{"$ref": "#/components/responses/NotFound"},
+				"406": map[string]interface{}{"$ref": "#/components/responses/NotAcceptable"},
+				"413": map[string]interface{}{"$ref": "#/components/responses/PayloadTooLarge"},
+				"415": map[string]interface{}{"$ref": "#/components/responses/UnsupportedMediaType"},
+				"429": map[string]interface{}{"$ref": "#/components/responses/TooManyRequests"},
+				"500": map[string]interface{}{"$ref": "#/components/responses/InternalError"},
+			},
+		},
+		"get": map[string]interface{}{
+			"summary":     "Describe the rules of group '" + groupName + "'",
+			"operationId": "describe_" + groupName,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "Group description",
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{"schema": map[string]interface{}{"type": "object"}},
+					},
+				},
+				"401": map[string]interface{}{"$ref": "#/components/responses/Unauthorized"},
+				"403": map[string]interface{}{"$ref": "#/components/responses/Forbidden"},
+				"404": map[string]interface{}{"$ref": "#/components/responses/NotFound"},
+			},
+		},
+	}
+}
+
+func errorResponse(description string) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content": map[string]interface{}{
+			"text/plain": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+		},
+	}
+}
+
+//HandleOpenAPI renders the OpenAPI specification of the registered groups the principal may access (GET /openapi.json)
+func HandleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, openAPISpec(groupAllowed(r)))
+}
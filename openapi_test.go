@@ -0,0 +1,64 @@
+package ruller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenAPISpec(t *testing.T) {
+	AddRequiredInput("openapi", "age", Float64)
+	DeclareOutput("openapi", "theme", String, "light", "dark")
+	Add("openapi", "rule1", func(ctx Context) (map[string]interface{}, error) {
+		return map[string]interface{}{"theme": "dark"}, nil
+	})
+
+	w := httptest.NewRecorder()
+	HandleOpenAPI(w, httptest.NewRequest("GET", "/openapi.json", nil))
+	assert.Equal(t, 200, w.Code)
+	spec := make(map[string]interface{})
+	err := json.Unmarshal(w.Body.Bytes(), &spec)
+	assert.Nil(t, err)
+	assert.Equal(t, "3.0.3", spec["openapi"])
+
+	post := spec["paths"].(map[string]interface{})["/rules/openapi"].(map[string]interface{})["post"].(map[string]interface{})
+	assert.Equal(t, false, post["requestBody"].(map[string]interface{})["required"])
+	input := post["requestBody"].(map[string]interface{})["content"].(map[string]interface{})["application/json"].(map[string]interface{})["schema"].(map[string]interface{})
+	assert.Equal(t, []interface{}{"age"}, input["required"])
+	properties := input["properties"].(map[string]interface{})
+	assert.Equal(t, "number", properties["age"].(map[string]interface{})["type"])
+	assert.Equal(t, "boolean", properties["_flatten"].(map[string]interface{})["type"])
+	assert.NotNil(t, properties["_keepFirst"])
+	assert.NotNil(t, properties["_info"])
+
+	responses := post["responses"].(map[string]interface{})
+	output := responses["200"].(map[string]interface{})["content"].(map[string]interface{})["application/json"].(map[string]interface{})["schema"].(map[string]interface{})
+	theme := output["properties"].(map[string]interface{})["theme"].(map[string]interface{})
+	assert.Equal(t, []interface{}{"light", "dark"}, theme["enum"])
+	for _, status := range []string{"400", "401", "403", "404", "406", "413", "415", "429", "500"} {
+		assert.NotNil(t, responses[status], status)
+	}
+	components := spec["components"].(map[string]interface{})["responses"].(map[string]interface{})
+	assert.NotNil(t, components["TooManyRequests"].(map[string]interface{})["headers"].(map[string]interface{})["Retry-After"])
+
+	//documented statuses are the ones returned
+	evaluate := func(groupName string, body string) int {
+		r := httptest.NewRequest("POST", "/rules/"+groupName, strings.NewReader(body))
+		r = mux.SetURLVars(r, map[string]string{"groupName": groupName})
+		w := httptest.NewRecorder()
+		HandleRuleGroup(w, r)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusBadRequest, evaluate("openapi", `{}`))
+	assert.Equal(t, http.StatusBadRequest, evaluate("openapi", `{"age":"old"}`))
+	assert.Equal(t, http.StatusNotFound, evaluate("openapi-unknown", `{}`))
+	SetOutputValidation("openapi", ValidationReject)
+	defer SetOutputValidation("openapi", ValidationLog)
+	DeclareOutput("openapi", "theme", String, "light")
+	assert.Equal(t, http.StatusBadRequest, evaluate("openapi", `{"age":1,"_flatten":true}`))
+}
@@ -0,0 +1,236 @@
+package ruller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	groupOverrides = make(map[string]map[string]map[string]map[string]interface{}) //[group][subject key][subject value]output
+	overridesMutex sync.RWMutex
+)
+
+//SetOverride forces an output for requests whose input attribute 'subjectKey' (ex.: "userId", "deviceId", "_remote_ip") equals 'subjectValue'.
+//Overrides are merged into the result of Process with highest precedence, regardless of rule logic. 'who' is recorded for auditing
+func SetOverride(groupName string, subjectKey string, subjectValue string, output map[string]interface{}, who string) error {
+	registryMutex.RLock()
+	_, exists := groupRules[groupName]
+	registryMutex.RUnlock()
+	if !exists {
+		return notFoundError{fmt.Errorf("Group %s doesn't exist", groupName)}
+	}
+
+	defer notifyGroupChanged(groupName)
+	overridesMutex.Lock()
+	defer overridesMutex.Unlock()
+	gk, exists := groupOverrides[groupName]
+	if !exists {
+		gk = make(map[string]map[string]map[string]interface{})
+		groupOverrides[groupName] = gk
+	}
+	kv, exists := gk[subjectKey]
+	if !exists {
+		kv = make(map[string]map[string]interface{})
+		gk[subjectKey] = kv
+	}
+	kv[subjectValue] = output
+
+	logrus.WithFields(logrus.Fields{
+		"audit":        "override-set",
+		"group":        groupName,
+		"subjectKey":   subjectKey,
+		"subjectValue": subjectValue,
+		"who":          who,
+	}).Infof("Override %s=%s of group '%s' set to %v by '%s'", subjectKey, subjectValue, groupName, output, who)
+	return nil
+}
+
+//RemoveOverride removes a previously forced output. Returns false if the override didn't exist
+func RemoveOverride(groupName string, subjectKey string, subjectValue string, who string) bool {
+	overridesMutex.Lock()
+	if _, exists := groupOverrides[groupName][subjectKey][subjectValue]; !exists {
+		overridesMutex.Unlock()
+		return false
+	}
+	delete(groupOverrides[groupName][subjectKey], subjectValue)
+	overridesMutex.Unlock()
+	defer notifyGroupChanged(groupName)
+
+	logrus.WithFields(logrus.Fields{
+		"audit":        "override-removed",
+		"group":        groupName,
+		"subjectKey":   subjectKey,
+		"subjectValue": subjectValue,
+		"who":          who,
+	}).Infof("Override %s=%s of group '%s' removed by '%s'", subjectKey, subjectValue, groupName, who)
+	return true
+}
+
+//setGroupOverrides replaces all overrides of a group, as when loading bundles
+func setGroupOverrides(groupName string, overrides map[string]map[string]map[string]interface{}) {
+	overridesMutex.Lock()
+	defer overridesMutex.Unlock()
+	gk := make(map[string]map[string]map[string]interface{})
+	for subjectKey, kv := range overrides {
+		gk[subjectKey] = make(map[string]map[string]interface{})
+		for subjectValue, output := range kv {
+			gk[subjectKey][subjectValue] = output
+		}
+	}
+	groupOverrides[groupName] = gk
+}
+
+//GetOverrides returns a copy of all overrides of a group in format [subject key][subject value]output
+func GetOverrides(groupName string) map[string]map[string]map[string]interface{} {
+	overridesMutex.RLock()
+	defer overridesMutex.RUnlock()
+	result := make(map[string]map[string]map[string]interface{})
+	for subjectKey, kv := range groupOverrides[groupName] {
+		if len(kv) == 0 {
+			continue
+		}
+		result[subjectKey] = make(map[string]map[string]interface{})
+		for subjectValue, output := range kv {
+			result[subjectKey][subjectValue] = output
+		}
+	}
+	return result
+}
+
+//applyOverrides merges the outputs forced for the subjects found in input. When more than one subject matches, subject keys are applied in alphabetical order and the first one wins.
+//When not flatten, forced values replace the values of every rule output with the same key and are only added to the top level result when no rule output has the key
+func applyOverrides(groupName string, input map[string]interface{}, output map[string]interface{}, sources map[string]string, options ProcessOptions) {
+	overridesMutex.RLock()
+	defer overridesMutex.RUnlock()
+	gk, exists := groupOverrides[groupName]
+	if !exists {
+		return
+	}
+
+	subjectKeys := make([]string, 0, len(gk))
+	for subjectKey := range gk {
+		subjectKeys = append(subjectKeys, subjectKey)
+	}
+	sort.Strings(subjectKeys)
+
+	applied := make(map[string]bool)
+	for _, subjectKey := range subjectKeys {
+		v, exists := input[subjectKey]
+		if !exists || v == nil {
+			continue
+		}
+		subjectValue := valueString(v)
+		forced, exists := gk[subjectKey][subjectValue]
+		if !exists {
+			continue
+		}
+		logrus.Debugf("Applying override %s=%s", subjectKey, subjectValue)
+		for k, fv := range forced {
+			if applied[k] {
+				continue
+			}
+			if options.FlattenOutput || !replaceRuleOutputs(output, k, fv, options.TreeOutput) {
+				output[k] = fv
+			}
+			applied[k] = true
+			setSource(sources, k, fmt.Sprintf("override:%s=%s", subjectKey, subjectValue))
+		}
+	}
+}
+
+//replaceRuleOutputs replaces the value of key in the rule outputs of a not flatten result, found in "_items" arrays or, for tree output, in the "output" of rule nodes.
+//Rule outputs are copied before being changed. Returns false if no rule output has the key
+func replaceRuleOutputs(result map[string]interface{}, key string, value interface{}, treeOutput bool) bool {
+	replaced := false
+	if treeOutput {
+		for _, v := range result {
+			node, ok := v.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if routput, ok := node["output"].(map[string]interface{}); ok {
+				if _, exists := routput[key]; exists {
+					routput = copyMap(routput)
+					routput[key] = value
+					node["output"] = routput
+					replaced = true
+				}
+			}
+			if children, ok := node["children"].(map[string]interface{}); ok && replaceRuleOutputs(children, key, value, true) {
+				replaced = true
+			}
+		}
+		return replaced
+	}
+
+	items, _ := result["_items"].([]map[string]interface{})
+	for i, item := range items {
+		if _, exists := item[key]; exists {
+			item = copyMap(item)
+			item[key] = value
+			items[i] = item
+			replaced = true
+		}
+		if replaceRuleOutputs(item, key, value, false) {
+			replaced = true
+		}
+	}
+	return replaced
+}
+
+//HandleOverrides lists (GET) overrides of a group or gets (GET), sets (PUT with the forced output map as body) or removes (DELETE) the override of a single subject
+func HandleOverrides(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	groupName := params["groupName"]
+	subjectKey := params["subjectKey"]
+	subjectValue := params["subjectValue"]
+
+	overrides := GetOverrides(groupName)
+	if subjectKey == "" {
+		writeJSON(w, overrides)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		output := make(map[string]interface{})
+		err := json.NewDecoder(r.Body).Decode(&output)
+		if err != nil {
+			http.Error(w, "Body must be a JSON object with the forced output. err="+err.Error(), 400)
+			return
+		}
+		err = SetOverride(groupName, subjectKey, subjectValue, output, requestPrincipal(r))
+		if err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+		writeJSON(w, output)
+	case http.MethodDelete:
+		if !RemoveOverride(groupName, subjectKey, subjectValue, requestPrincipal(r)) {
+			http.Error(w, "Override not found", 404)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		output, exists := overrides[subjectKey][subjectValue]
+		if !exists {
+			http.Error(w, "Override not found", 404)
+			return
+		}
+		writeJSON(w, output)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		logrus.Warnf("Error writing response. err=%s", err)
+	}
+}
@@ -0,0 +1,77 @@
+package ruller
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOverrides(t *testing.T) {
+	Add("overrides", "rule1", func(ctx Context) (map[string]interface{}, error) {
+		return map[string]interface{}{"theme": "light", "beta": false}, nil
+	})
+
+	router := mux.NewRouter()
+	router.HandleFunc("/admin/overrides/{groupName}", HandleOverrides)
+	router.HandleFunc("/admin/overrides/{groupName}/{subjectKey}/{subjectValue}", HandleOverrides)
+	r, _ := http.NewRequest("PUT", "/admin/overrides/overrides/userId/123", bytes.NewBufferString(`{"beta":true}`))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	options := ProcessOptions{FlattenOutput: true, MergeKeepFirst: true, Explain: true}
+	output, err := Process("overrides", map[string]interface{}{"userId": 123.0}, options)
+	assert.Nil(t, err)
+	assert.Equal(t, true, output["beta"])
+	assert.Equal(t, "light", output["theme"])
+	sources := output["_explain"].(map[string]interface{})["sources"].(map[string]string)
+	assert.Equal(t, "override:userId=123", sources["beta"])
+	assert.Equal(t, "rule1", sources["theme"])
+
+	output, err = Process("overrides", map[string]interface{}{"userId": 456.0}, options)
+	assert.Nil(t, err)
+	assert.Equal(t, false, output["beta"])
+
+	assert.Nil(t, SetOverride("overrides", "userId", "1234567", map[string]interface{}{"beta": true, "id": "forced"}, "test"))
+	output, err = Process("overrides", map[string]interface{}{"userId": 1234567.0}, options)
+	assert.Nil(t, err)
+	assert.Equal(t, true, output["beta"])
+
+	output, err = Process("overrides", map[string]interface{}{"userId": 1234567.0}, ProcessOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"_items": []map[string]interface{}{{"theme": "light", "beta": true}}, "id": "forced"}, output)
+
+	output, err = Process("overrides", map[string]interface{}{"userId": 1234567.0}, ProcessOptions{TreeOutput: true})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"theme": "light", "beta": true}, output["rule1"].(map[string]interface{})["output"])
+	assert.Nil(t, output["beta"])
+	RemoveOverride("overrides", "userId", "1234567", "test")
+
+	output, err = Process("overrides", map[string]interface{}{"userId": 1234567.0}, ProcessOptions{TreeOutput: true})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"theme": "light", "beta": false}, output["rule1"].(map[string]interface{})["output"], "rule outputs must not be changed by overrides")
+
+	assert.NotNil(t, SetOverride("unknown-group", "userId", "1", map[string]interface{}{"beta": true}, "test"))
+	r, _ = http.NewRequest("PUT", "/admin/overrides/unknown-group/userId/1", bytes.NewBufferString(`{"beta":true}`))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	r, _ = http.NewRequest("GET", "/admin/overrides/overrides", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	assert.JSONEq(t, `{"userId":{"123":{"beta":true}}}`, w.Body.String())
+
+	r, _ = http.NewRequest("DELETE", "/admin/overrides/overrides/userId/123", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	output, err = Process("overrides", map[string]interface{}{"userId": 123.0}, options)
+	assert.Nil(t, err)
+	assert.Equal(t, false, output["beta"])
+}
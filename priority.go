@@ -0,0 +1,49 @@
+package ruller
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+)
+
+//SetPriority sets the priority of a rule among its siblings. Rules with higher priority are evaluated (and merged) first.
+//Rules with the same priority are evaluated in registration order. Default priority is 0
+func SetPriority(groupName string, ruleName string, priority int) error {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	return setPriority(groupName, ruleName, priority)
+}
+
+func setPriority(groupName string, ruleName string, priority int) error {
+	rules, exists := rulesMap[groupName]
+	if !exists {
+		return fmt.Errorf("Group %s doesn't exist", groupName)
+	}
+	rinfo, exists := rules[ruleName]
+	if !exists {
+		return fmt.Errorf("Rule '%s' not found in group '%s'", ruleName, groupName)
+	}
+	logrus.Debugf("Setting priority of rule '%s' of group '%s' to %d", ruleName, groupName, priority)
+	if rinfo.parentName == "" {
+		rinfo.priority = priority
+		sortRules(groupRules[groupName])
+		return nil
+	}
+	parent, exists := rules[rinfo.parentName]
+	if !exists {
+		return fmt.Errorf("Parent rule '%s' of rule '%s' not found in group '%s'", rinfo.parentName, ruleName, groupName)
+	}
+	rinfo.priority = priority
+	sortRules(parent.children)
+	return nil
+}
+
+func sortRules(rules []*ruleInfo) {
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].priority != rules[j].priority {
+			return rules[i].priority > rules[j].priority
+		}
+		return rules[i].seq < rules[j].seq
+	})
+}
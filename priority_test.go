@@ -0,0 +1,75 @@
+package ruller
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRulePriority(t *testing.T) {
+	constant := func(value string) Rule {
+		return func(ctx Context) (map[string]interface{}, error) {
+			return map[string]interface{}{"theme": value}, nil
+		}
+	}
+	Add("priority", "low", constant("low"))
+	Add("priority", "default", constant("default"))
+	Add("priority", "high", constant("high"))
+	AddChild("priority", "high.1", "high", constant("high.1"))
+	AddChild("priority", "high.2", "high", constant("high.2"))
+
+	options := ProcessOptions{FlattenOutput: true, MergeKeepFirst: true}
+	output, err := Process("priority", map[string]interface{}{}, options)
+	assert.Nil(t, err)
+	assert.Equal(t, "low", output["theme"])
+
+	assert.Nil(t, SetPriority("priority", "low", -1))
+	assert.Nil(t, SetPriority("priority", "high", 10))
+	assert.Nil(t, SetPriority("priority", "high.2", 1))
+	assert.NotNil(t, SetPriority("priority", "unknown", 1))
+	assert.NotNil(t, SetPriority("priority-unknown", "low", 1))
+
+	output, err = Process("priority", map[string]interface{}{}, options)
+	assert.Nil(t, err)
+	assert.Equal(t, "high.2", output["theme"])
+
+	desc, err := DescribeGroup("priority")
+	assert.Nil(t, err)
+	assert.Equal(t, "high", desc.Rules[0].Name)
+	assert.Equal(t, "default", desc.Rules[1].Name)
+	assert.Equal(t, "low", desc.Rules[2].Name)
+	assert.Equal(t, "high.2", desc.Rules[0].Children[0].Name)
+	assert.Equal(t, 10, desc.Rules[0].Priority)
+}
+
+func TestRegistryConcurrentChanges(t *testing.T) {
+	Add("registry", "rule1", func(ctx Context) (map[string]interface{}, error) {
+		return map[string]interface{}{"score": 1.0}, nil
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			SetGroupStrategy("registry", EvaluateAll)
+			SetChildrenStrategy("registry", "rule1", EvaluateFirstMatch)
+			SetOutputKeys("registry", "rule1", "score")
+			SetKeyMergeStrategy("registry", "score", SumValues)
+			SetDefaultTreeOutput("registry", false)
+			DeclareOutput("registry", "score", Float64)
+			SetRuleEnabled("registry", "rule1", true, "test")
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := Evaluate("registry", map[string]interface{}{"_flatten": true})
+			assert.Nil(t, err)
+			_, err = DescribeGroup("registry")
+			assert.Nil(t, err)
+			assert.Contains(t, GroupNames(), "registry")
+			_, err = GetRuleState("registry", "rule1")
+			assert.Nil(t, err)
+		}()
+	}
+	wg.Wait()
+}
@@ -1,14 +1,10 @@
 package ruller
 
 import (
-	"bufio"
-	"encoding/csv"
-	"encoding/json"
+	"context"
+	"crypto/sha1"
 	"flag"
 	"fmt"
-	"io"
-	"io/ioutil"
-	"net"
 	"net/http"
 	"os"
 	"reflect"
@@ -16,10 +12,8 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/gorilla/websocket"
 	"github.com/oschwald/geoip2-golang"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 )
 
@@ -33,6 +27,10 @@ const (
 	Float64
 	//Bool input type
 	Bool
+	//Object input type (a map of attributes)
+	Object
+	//Array input type
+	Array
 )
 
 var (
@@ -41,15 +39,15 @@ var (
 	rulesMap                          = make(map[string]map[string]*ruleInfo)
 	groupFlatten                      = make(map[string]bool)
 	groupKeepFirst                    = make(map[string]bool)
+	groupTreeOutput                   = make(map[string]bool)
+	groupStrategy                     = make(map[string]EvaluationStrategy)
+	ruleSeq                           = 0
 	requestFilter      RequestFilter  = func(r *http.Request, input map[string]interface{}) error { return nil }
-	responseFilter     ResponseFilter = func(w http.ResponseWriter, input map[string]interface{}, output map[string]interface{}, outBytes []byte) (bool, error) {
+	responseFilter     ResponseFilter = func(w http.ResponseWriter, input map[string]interface{}, output map[string]interface{}, outBytes []byte, mediaType string) (bool, error) {
 		return false, nil
 	}
 	geodb     = (*geoip2.Reader)(nil)
 	cityState = make(map[string]map[string]string) //[country][city]state
-	origins string;
-	allow_methods string;
-	allow_headers string;
 )
 
 var rulesProcessingHist = prometheus.NewHistogramVec(prometheus.HistogramOpts{
@@ -77,14 +75,16 @@ type Rule func(Context) (map[string]interface{}, error)
 type RequestFilter func(r *http.Request, input map[string]interface{}) error
 
 //ResponseFilter Function called on every HTTP call after rules processing.
-//params: http response writer, input attribute, output attributes.
+//params: http response writer, input attribute, output attributes, encoded output, media type negotiated with the client (see RegisterEncoder).
 //returns: bool true if ruller should interrupt renderization and rely on what the filter did, error
-type ResponseFilter func(w http.ResponseWriter, input map[string]interface{}, output map[string]interface{}, outBytes []byte) (bool, error)
+type ResponseFilter func(w http.ResponseWriter, input map[string]interface{}, output map[string]interface{}, outBytes []byte, mediaType string) (bool, error)
 
 //Context used as input for rule processing
 type Context struct {
 	Input          map[string]interface{}
 	ChildrenOutput map[string]interface{}
+	//Dependencies outputs of the rules declared as dependencies with AddDependency, by rule name. Disabled dependencies or dependencies with no output are absent
+	Dependencies map[string]map[string]interface{}
 }
 
 //ProcessOptions options for rule process
@@ -95,13 +95,37 @@ type ProcessOptions struct {
 	AddRuleInfo bool
 	//Get all rules's results and merge all outputs into a single flat map. If false, the output will come the same way as the hierarchy of rules. Defaults to true
 	FlattenOutput bool
+	//TreeOutput When not flatten, output one node per rule name in format {"output": {..}, "children": {..}, "_rule": ".."}. When false, the shape of previous versions is used:
+	//rule outputs (with children attributes copied into them) are appended to anonymous "_items" arrays and rule info is added to flatten output only. Defaults to false
+	TreeOutput bool
+	//Explain Add an "_explain" attribute to the output describing where each output attribute came from (rule name or override). Defaults to false
+	Explain bool
+	//Fields When defined, only these output attributes are returned. Nested attributes may be selected with dotted paths (ex.: "menu.title").
+	//In tree output, fields are selected in the output of each rule node and nodes left without output or children are removed.
+	//In flatten mode, rules that declared their output keys (see SetOutputKeys) and can't output any of the fields are not even evaluated
+	Fields []string
 }
 
 type ruleInfo struct {
+	groupName  string
 	name       string
 	parentName string
 	rule       Rule
 	children   []*ruleInfo
+	//dependencies rules that must be evaluated before this one and whose outputs are passed in Context
+	dependencies []*ruleInfo
+	//dependent true if some other rule depends on this rule output
+	dependent bool
+	//childrenStrategy how the children of this rule are evaluated
+	childrenStrategy EvaluationStrategy
+	//priority rules with higher priority are evaluated first among their siblings
+	priority int
+	//seq registration order. used as tie breaker for priority
+	seq int
+	//outputKeys top level output keys this rule may produce. nil if not declared
+	outputKeys []string
+	//declaration source of declarative rules. nil for Go function rules, which can only be evaluated by the server
+	declaration *DeclarativeRule
 }
 
 //SetRequestFilter set the function that will be called at every call
@@ -109,7 +133,7 @@ func SetRequestFilter(rf RequestFilter) {
 	requestFilter = rf
 }
 
-//SetResponseFilter set the function that will be called at every call with output. If returns true, won't perform the default JSON renderization
+//SetResponseFilter set the function that will be called at every call with output. If returns true, won't perform the default renderization
 func SetResponseFilter(rf ResponseFilter) {
 	responseFilter = rf
 }
@@ -128,6 +152,13 @@ func SetDefaultKeepFirst(groupName string, value bool) {
 	}
 }
 
+//SetDefaultTreeOutput sets whatever to use the rule-keyed tree output (see ProcessOptions.TreeOutput) when not flatten. This may be overriden during rules evaluation with a "_treeOutput" attribute in input
+func SetDefaultTreeOutput(groupName string, value bool) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	groupTreeOutput[groupName] = value
+}
+
 //AddRequiredInput adds a input attribute name that is required before processing the rules
 func AddRequiredInput(groupName string, inputName string, it InputType) {
 	logrus.Debugf("Adding required input. group=%s. attribute=%s", groupName, inputName)
@@ -156,16 +187,20 @@ func AddChild(groupName string, ruleName string, parentRuleName string, rule Rul
 	}
 
 	rulei := ruleInfo{
+		groupName:  groupName,
 		name:       ruleName,
 		parentName: parentRuleName,
 		rule:       rule,
 		children:   make([]*ruleInfo, 0),
+		seq:        ruleSeq,
 	}
+	ruleSeq++
 	rulesMap[groupName][ruleName] = &rulei
 
 	if parentRuleName == "" {
 		logrus.Debugf("Rule %s is a root rule", ruleName)
 		groupRules[groupName] = append(groupRules[groupName], &rulei)
+		sortRules(groupRules[groupName])
 
 	} else {
 		logrus.Debugf("Adding child rule '%s' to parent", ruleName)
@@ -175,13 +210,32 @@ func AddChild(groupName string, ruleName string, parentRuleName string, rule Rul
 		}
 		logrus.Debugf("Parent of %v is %v", rule, parentRule.rule)
 		parentRule.children = append(parentRule.children, &rulei)
+		sortRules(parentRule.children)
 	}
 	groupRuleCount.WithLabelValues(groupName).Inc()
+	setRuleEnabledGauge(groupName, ruleName, true)
 	return nil
 }
 
 //Process process all rules in a group and return a resulting map with all values returned by the rules
 func Process(groupName string, input map[string]interface{}, options ProcessOptions) (map[string]interface{}, error) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	return process(groupName, input, options)
+}
+
+//Evaluate processes all rules in a group using the special attributes of input ("_flatten", "_fields" etc) as options, the same way the REST API does
+func Evaluate(groupName string, input map[string]interface{}) (map[string]interface{}, error) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	options, err := processOptions(groupName, input)
+	if err != nil {
+		return nil, err
+	}
+	return process(groupName, input, options)
+}
+
+func process(groupName string, input map[string]interface{}, options ProcessOptions) (map[string]interface{}, error) {
 	logrus.Debugf(">>>Processing rules from group '%s' with input map %s", groupName, input)
 
 	logrus.Debugf("Validating required input attributes")
@@ -194,7 +248,7 @@ func Process(groupName string, input map[string]interface{}, options ProcessOpti
 		} else {
 			actualType := reflect.TypeOf(v)
 			if requiredType == Float64 {
-				if actualType.Kind() != reflect.Float64 {
+				if _, ok := toFloat64(v); !ok {
 					wrongTypeInput = fmt.Sprintf("%s%s must be of type %v; ", wrongTypeInput, k, "numeric")
 				}
 			} else if requiredType == String {
@@ -205,88 +259,278 @@ func Process(groupName string, input map[string]interface{}, options ProcessOpti
 				if actualType.Kind() != reflect.Bool {
 					wrongTypeInput = fmt.Sprintf("%s%s must be of type %v; ", wrongTypeInput, k, "bool")
 				}
+			} else if !matchesType(v, requiredType) {
+				wrongTypeInput = fmt.Sprintf("%s%s must be of type %v; ", wrongTypeInput, k, requiredType)
 			}
 		}
 	}
 	if missingInput != "" {
-		return nil, fmt.Errorf("Missing required input attributes: %s", missingInput)
+		return nil, badRequest(fmt.Errorf("Missing required input attributes: %s", missingInput))
 	}
 	if wrongTypeInput != "" {
-		return nil, fmt.Errorf("Input attribute with incorrect type: %s", wrongTypeInput)
+		return nil, badRequest(fmt.Errorf("Input attribute with incorrect type: %s", wrongTypeInput))
 	}
 
 	rules, exists := groupRules[groupName]
 	if !exists {
-		return nil, fmt.Errorf("Group %s doesn't exist", groupName)
+		return nil, notFoundError{fmt.Errorf("Group %s doesn't exist", groupName)}
 	}
 	logrus.Debugf("Invoking all rules from group %s", groupName)
 	start := time.Now()
-	result, err := processRules(rules, input, options)
+	e := evaluation{groupName: groupName, input: input, options: options}
+	result, sources, err := e.processRules(rules, groupStrategy[groupName])
 	status := "2xx"
 	if err != nil {
 		status = "5xx"
 	}
 	rulesProcessingHist.WithLabelValues(groupName, status).Observe(time.Since(start).Seconds())
-	return result, err
+	if err != nil {
+		return result, err
+	}
+
+	applyOverrides(groupName, input, result, sources, options)
+	if options.FlattenOutput {
+		err = validateOutput(groupName, result)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(options.Fields) > 0 {
+		if !options.FlattenOutput && options.TreeOutput {
+			result = projectTreeFields(result, options.Fields)
+		} else {
+			result = projectFields(result, options.Fields)
+		}
+	}
+	if options.Explain {
+		if e.conflicts == nil {
+			e.conflicts = make([]MergeConflict, 0)
+		}
+		result["_explain"] = map[string]interface{}{"sources": sources, "conflicts": e.conflicts}
+	}
+	return result, nil
+}
+
+//evaluation state of a single group processing
+type evaluation struct {
+	groupName string
+	input     map[string]interface{}
+	options   ProcessOptions
+	//results rules already evaluated during this processing, so that each rule is evaluated at most once
+	results map[string]*ruleResult
+	//conflicts keys merged from different rules with different values. only tracked when explaining
+	conflicts []MergeConflict
+}
+
+type ruleResult struct {
+	output          map[string]interface{}
+	childrenOutput  map[string]interface{}
+	childrenSources map[string]string
+	done            bool
+	stop            bool
 }
 
-func processRules(rules []*ruleInfo, input map[string]interface{}, options ProcessOptions) (map[string]interface{}, error) {
+//processRules returns the merged output of rules and, when explaining, the name of the rule that produced each output key
+func (e *evaluation) processRules(rules []*ruleInfo, strategy EvaluationStrategy) (map[string]interface{}, map[string]string, error) {
 	output := make(map[string]interface{})
+	var sources map[string]string
+	if e.options.Explain {
+		sources = make(map[string]string)
+	}
 	for _, rinfo := range rules {
-		childrenOutput := make(map[string]interface{})
-		if len(rinfo.children) > 0 {
-			logrus.Debugf("Rule '%s': processing %d children rules before itself", rinfo.name, len(rinfo.children))
-			co, err := processRules(rinfo.children, input, options)
-			if err != nil {
-				return nil, err
-			}
-			childrenOutput = co
-		} else {
-			logrus.Debugf("No children found for %v", rinfo)
+		if !IsRuleEnabled(rinfo.groupName, rinfo.name) {
+			logrus.Debugf("Rule '%s' is disabled. Skipping it and its children", rinfo.name)
+			continue
 		}
-
-		rule := rinfo.rule
-		logrus.Debugf("Invoking rule '%s' '%v'", rinfo.name, rule)
-		ctx := Context{Input: input, ChildrenOutput: childrenOutput}
-		routput, err := rule(ctx)
+		if strategy == EvaluateAll && !e.mayContribute(rinfo) {
+			logrus.Debugf("Rule '%s' can't output any of the requested fields. Skipping it", rinfo.name)
+			continue
+		}
+		result, err := e.evaluateRule(rinfo)
 		if err != nil {
-			return nil, fmt.Errorf("Error processing rule %s. err=%s", rinfo.name, err)
+			return nil, nil, err
 		}
+		childrenOutput := result.childrenOutput
+		childrenSources := result.childrenSources
+		routput := result.output
 		if routput == nil {
 			logrus.Debugf("Rule '%s' has no output", rinfo.name)
+			if !e.options.FlattenOutput && e.options.TreeOutput && len(childrenOutput) > 0 {
+				//children outputs are kept under the node of their parent
+				output[rinfo.name] = e.treeNode(rinfo, make(map[string]interface{}), childrenOutput)
+			}
+			if result.stop {
+				logrus.Debugf("Rule '%s' requested to stop processing its siblings", rinfo.name)
+				break
+			}
 			continue
 		}
+		if !e.options.FlattenOutput && e.options.TreeOutput {
+			output[rinfo.name] = e.treeNode(rinfo, routput, childrenOutput)
+		} else {
+			if rinfo.dependent {
+				//dependents received this map in their context. don't change it
+				routput = copyMap(routput)
+			}
+
+			if e.options.AddRuleInfo && e.options.FlattenOutput && !e.options.TreeOutput {
+				routput["_rule"] = rinfo.name
+			}
+
+			var rsources map[string]string
+			if sources != nil {
+				rsources = make(map[string]string)
+				for k := range routput {
+					rsources[k] = rinfo.name
+				}
+				for k := range childrenOutput {
+					rsources[k] = childrenSources[k]
+				}
+			}
+
+			for k, v := range childrenOutput {
+				existing, exists := routput[k]
+				strategy, explicit := e.mergeStrategy(k)
+				if exists && explicit {
+					merged, err := strategy.Merge(k, existing, v)
+					if err != nil {
+						return nil, nil, err
+					}
+					routput[k] = merged
+				} else {
+					routput[k] = v
+				}
+			}
+
+			err = e.mergeMaps(rinfo, routput, &output, rsources, sources)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		if result.stop {
+			logrus.Debugf("Rule '%s' requested to stop processing its siblings", rinfo.name)
+			break
+		}
+		if strategy == EvaluateFirstMatch {
+			logrus.Debugf("Rule '%s' matched. Skipping its siblings (first match)", rinfo.name)
+			break
+		}
+	}
+	return output, sources, nil
+}
+
+//treeNode creates the node of a rule for tree output
+func (e *evaluation) treeNode(rinfo *ruleInfo, routput map[string]interface{}, childrenOutput map[string]interface{}) map[string]interface{} {
+	node := map[string]interface{}{"output": routput}
+	if len(childrenOutput) > 0 {
+		node["children"] = childrenOutput
+	}
+	if e.options.AddRuleInfo {
+		node["_rule"] = rinfo.name
+	}
+	return node
+}
+
+//evaluateRule evaluates the children and dependencies of a rule and then the rule itself. Results are reused if the rule was already evaluated
+func (e *evaluation) evaluateRule(rinfo *ruleInfo) (*ruleResult, error) {
+	result := &ruleResult{childrenOutput: make(map[string]interface{})}
+	if rinfo.dependent {
+		//only rules that other rules depend on may be reached more than once
+		if e.results == nil {
+			e.results = make(map[string]*ruleResult)
+		}
+		previous, exists := e.results[rinfo.name]
+		if exists {
+			if !previous.done {
+				return nil, fmt.Errorf("Dependency cycle detected at rule %s", rinfo.name)
+			}
+			logrus.Debugf("Rule '%s' already evaluated", rinfo.name)
+			return previous, nil
+		}
+		e.results[rinfo.name] = result
+	}
 
-		if options.AddRuleInfo && options.FlattenOutput {
-			routput["_rule"] = rinfo.name
+	if len(rinfo.children) > 0 {
+		logrus.Debugf("Rule '%s': processing %d children rules before itself", rinfo.name, len(rinfo.children))
+		co, cs, err := e.processRules(rinfo.children, rinfo.childrenStrategy)
+		if err != nil {
+			return nil, err
 		}
+		result.childrenOutput = co
+		result.childrenSources = cs
+	} else {
+		logrus.Debugf("No children found for %v", rinfo)
+	}
 
-		for k, v := range childrenOutput {
-			routput[k] = v
+	var dependencies map[string]map[string]interface{}
+	if len(rinfo.dependencies) > 0 {
+		logrus.Debugf("Rule '%s': evaluating %d dependencies before itself", rinfo.name, len(rinfo.dependencies))
+		dependencies = make(map[string]map[string]interface{})
+		for _, dinfo := range rinfo.dependencies {
+			if !IsRuleEnabled(dinfo.groupName, dinfo.name) {
+				logrus.Debugf("Dependency '%s' is disabled", dinfo.name)
+				continue
+			}
+			dresult, err := e.evaluateRule(dinfo)
+			if err != nil {
+				return nil, err
+			}
+			if dresult.output != nil {
+				dependencies[dinfo.name] = dresult.output
+			}
 		}
+	}
+
+	rule := rinfo.rule
+	logrus.Debugf("Invoking rule '%s' '%v'", rinfo.name, rule)
+	ctx := Context{Input: e.input, ChildrenOutput: result.childrenOutput, Dependencies: dependencies}
+	routput, err := rule(ctx)
+	if err == ErrStopProcessing {
+		result.stop = true
+	} else if err != nil {
+		return nil, fmt.Errorf("Error processing rule %s. err=%s", rinfo.name, err)
+	}
+	result.output = routput
+	result.done = true
+	return result, nil
+}
 
-		mergeMaps(rinfo, routput, &output, options)
+func copyMap(m map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		c[k] = v
 	}
-	return output, nil
+	return c
 }
 
-func mergeMaps(rinfo *ruleInfo, sourceMap map[string]interface{}, destMapP *map[string]interface{}, options ProcessOptions) {
+func (e *evaluation) mergeMaps(rinfo *ruleInfo, sourceMap map[string]interface{}, destMapP *map[string]interface{}, sourceSources map[string]string, destSources map[string]string) error {
 	destMap := *destMapP
 	logrus.Debugf("Merging map %v to %v", sourceMap, destMap)
 	if len(sourceMap) > 0 {
-		if options.FlattenOutput {
+		if e.options.FlattenOutput {
 			logrus.Debugf("Merge results (flatten)")
 			for k, v := range sourceMap {
-				_, exists := destMap[k]
+				existing, exists := destMap[k]
 				if exists {
-					if options.MergeKeepFirst {
-						logrus.Debugf("Skipping key '%s' because it already exists in output", k)
-					} else {
-						destMap[k] = v
-						logrus.Debugf("Replacing existing key '%s' in output", k)
+					strategy, _ := e.mergeStrategy(k)
+					merged, err := strategy.Merge(k, existing, v)
+					if err != nil {
+						return err
+					}
+					logrus.Debugf("Merged existing key '%s' in output", k)
+					destMap[k] = merged
+					if e.options.Explain {
+						if !reflect.DeepEqual(existing, v) && !isReservedKey(k) {
+							e.conflicts = append(e.conflicts, MergeConflict{Key: k, Sources: []string{destSources[k], sourceSources[k]}})
+						}
+						if !reflect.DeepEqual(merged, existing) {
+							setSource(destSources, k, sourceSources[k])
+						}
 					}
 				} else {
 					destMap[k] = v
+					setSource(destSources, k, sourceSources[k])
 				}
 			}
 		} else {
@@ -299,136 +543,44 @@ func mergeMaps(rinfo *ruleInfo, sourceMap map[string]interface{}, destMapP *map[
 			destMap["_items"] = rmap
 		}
 	}
+	return nil
 }
 
-func Middleware(next http.Handler) http.Handler {
-    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {		
-		w.Header().Set("Access-Control-Allow-Origin", origins)
-		w.Header().Set("Access-Control-Allow-Methods", allow_methods)
-		w.Header().Set("Access-Control-Allow-Headers", allow_headers)
-
-		if (r.Method == "OPTIONS") {
-			//handle preflight in here
-			logrus.Debugf("OPTIONS Method")
-		  } else {
-			next.ServeHTTP(w, r)
-		  }
-    })
+func setSource(sources map[string]string, key string, source string) {
+	if sources != nil {
+		sources[key] = source
+	}
 }
 
-
-//StartServer Initialize and start REST server
+//StartServer Initialize and start REST server. Configuration is read from flags of the global flag set (parsed here), RULLER_* environment variables and config file (see LoadServerConfig)
 func StartServer() error {
-	origins = *(flag.String("origins", "", "Allowed Origins"))
-	allow_methods =  *(flag.String("allow-methods", "POST, GET, OPTIONS", "Allowed Methods"))
-	allow_headers = *(flag.String("allow-headers", "Accept, Accept-Encoding, Cache-Control, User-Agent, Accept-Language, Content-Type", "Allowed Headers"))
-	listenPort := flag.Int("listen-port", 3000, "REST API server listen port")
-	listenIP := flag.String("listen-address", "0.0.0.0", "REST API server listen ip address")
-	geolitedb := flag.String("geolite2-db", "", "Geolite mmdb database file. If not defined, localization info based on IP will be disabled")
-	geocitystatedb := flag.String("city-state-db", "", "City->State database file in CSV format 'country-code,city,state'. If defined, input '_ip_state' will be calculated according to '_ip_city'.")
-	logLevel := flag.String("log-level", "info", "debug, info, warning or error")
-	ws := flag.Bool("ws", true, "Enable dummy WS at /ws (useful for detecting ruller restarts)")
-	flag.Parse()
-
-	switch *logLevel {
-	case "debug":
-		logrus.SetLevel(logrus.DebugLevel)
-		break
-	case "warning":
-		logrus.SetLevel(logrus.WarnLevel)
-		break
-	case "error":
-		logrus.SetLevel(logrus.ErrorLevel)
-		break
-	default:
-		logrus.SetLevel(logrus.InfoLevel)
-	}
-
-	prometheus.MustRegister(rulesProcessingHist)
-	prometheus.MustRegister(groupRuleCount)
-
-	gf := *geolitedb
-	if gf == "" {
-		logrus.Infof("Geolite database file not found. Localization capabilities based on IP will be disabled")
-	} else {
-		logrus.Debugf("Loading GeoIP2 database %s", gf)
-		gdb, err := geoip2.Open(gf)
-		if err != nil {
-			return err
-		}
-		geodb = gdb
-		defer geodb.Close()
-		logrus.Infof("GeoIP2 database loaded")
-
-		cs := *geocitystatedb
-		if cs == "" {
-			logrus.Infof("City State csv file not defined. _ip_state input won't be available")
-		} else {
-			logrus.Debugf("Loading City State CSV file %s", cs)
-			csvFile, err := os.Open(cs)
-			if err != nil {
-				return err
-			}
-			reader := csv.NewReader(bufio.NewReader(csvFile))
-			for {
-				line, err := reader.Read()
-				if err == io.EOF {
-					break
-				} else if err != nil {
-					return err
-				}
-				country := strings.ToLower(line[0])
-				city := strings.ToLower(line[1])
-				state := line[2]
-				cm, exists := cityState[country]
-				if !exists {
-					cm = make(map[string]string)
-					cityState[country] = cm
-				}
-				cm[city] = state
-			}
-			logrus.Infof("City State CSV loaded")
-		}
-	}
-
-	router := mux.NewRouter()
-	router.HandleFunc("/rules/{groupName}", HandleRuleGroup).Methods("POST","OPTIONS")
-	router.Handle("/metrics", promhttp.Handler())
-	router.Use(Middleware)
-
-	if *ws {
-		router.HandleFunc("/ws", dummyWS)
-	}
-	listen := fmt.Sprintf("%s:%d", *listenIP, *listenPort)
-	logrus.Infof("Listening at %s", listen)
-	err := http.ListenAndServe(listen, router)
+	cfg, err := LoadServerConfig(flag.CommandLine, os.Args[1:])
 	if err != nil {
 		return err
 	}
-	return nil
+	return StartServerWithConfig(context.Background(), cfg)
 }
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
-} // use default options
-
-func dummyWS(w http.ResponseWriter, r *http.Request) {
-	c, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		logrus.Warnf("ws upgrade err: %s", err)
-		return
-	}
-	defer c.Close()
-	c.SetReadLimit(10)
-	for {
-		_, _, err := c.ReadMessage()
-		if err != nil {
-			logrus.Warnf("ws read err: %s", err)
+//StartServerWithConfig Initialize and start REST server (and gRPC server, if configured). Returns when the server fails or after ctx is done and the server was shut down
+func StartServerWithConfig(ctx context.Context, cfg ServerConfig) error {
+	server := NewServer(cfg)
+	//closed when Start returns, so that the shutdown goroutine doesn't outlive a server that failed to start
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stopped:
 			return
 		}
-	}
+		sctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		err := server.Shutdown(sctx)
+		if err != nil {
+			logrus.Warnf("Error shutting down server. err=%s", err)
+		}
+	}()
+	return server.Start()
 }
 
 func HandleRuleGroup(w http.ResponseWriter, r *http.Request) {
@@ -439,8 +591,29 @@ func HandleRuleGroup(w http.ResponseWriter, r *http.Request) {
 
 	logrus.Debugf("processRuleGroup r=%s", groupName)
 
-	logrus.Debugf("Parsing input json to map")
-	bodyBytes, err := ioutil.ReadAll(r.Body)
+	//responses of the same request differ by the Accept header, so caches must not share them
+	w.Header().Add("Vary", "Accept")
+	mediaType, encoder, err := negotiateEncoder(r.Header.Get("Accept"))
+	if err != nil {
+		logrus.Debugf(err.Error())
+		http.Error(w, err.Error(), http.StatusNotAcceptable)
+		return
+	}
+
+	contentType, decoder, err := decoderFor(r.Header.Get("Content-Type"))
+	if err != nil {
+		logrus.Debugf(err.Error())
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	logrus.Debugf("Parsing input %s to map", contentType)
+	bodyBytes, err := readBody(r.Body, r.ContentLength)
+	if err == errBodyTooLarge {
+		logrus.Debugf("Request body larger than %d bytes", inputLimits.MaxBodySize)
+		http.Error(w, fmt.Sprintf("Request body larger than %d bytes", inputLimits.MaxBodySize), http.StatusRequestEntityTooLarge)
+		return
+	}
 	if err != nil {
 		logrus.Warnf("Error reading request body. err=%s", err)
 		http.Error(w, "Error reading request body", 500)
@@ -449,109 +622,42 @@ func HandleRuleGroup(w http.ResponseWriter, r *http.Request) {
 
 	pinput := make(map[string]interface{})
 	if len(bodyBytes) > 0 {
-		err = json.Unmarshal(bodyBytes, &pinput)
+		pinput, err = decoder.Decode(bodyBytes)
 		if err != nil {
-			logrus.Warnf("Error parsing json body to map. err=%s", err)
-			http.Error(w, "Invalid input JSON. err="+err.Error(), 500)
+			logrus.Debugf("Error parsing %s body to map. err=%s", contentType, err)
+			http.Error(w, "Invalid input. err="+err.Error(), http.StatusBadRequest)
 			return
 		}
-	}
-
-	ipStr := r.Header.Get("X-Forwarded-For")
-	if ipStr == "" {
-		ra := strings.Split(r.RemoteAddr, ":")
-		if len(ra) > 0 {
-			ipStr = ra[0]
-		}
-	}
-	if ipStr == "" {
-		ipStr = "0.0.0.0"
-	}
-	pinput["_remote_ip"] = ipStr
-	pinput["_ip_country"] = ""
-	pinput["_ip_city"] = ""
-	pinput["_ip_state"] = ""
-	pinput["_ip_latitude"] = 0
-	pinput["_ip_longitude"] = 0
-	pinput["_ip_accuracy_radius"] = 999999
-
-	if geodb != nil {
-		pinput["_remote_ip"] = ipStr
-		ip := net.ParseIP(ipStr)
-		start := time.Now()
-		ipRecord, err := geodb.City(ip)
-		logrus.Debugf("Time to find getIp data: %s", time.Since(start))
-		if err != nil {
-			logrus.Warnf("Couldn't find geo info for ip %s. err=%s", ipStr, err)
-		} else {
-			pinput["_ip_country"] = ipRecord.Country.Names["en"]
-			pinput["_ip_city"] = ipRecord.City.Names["en"]
-			pinput["_ip_latitude"] = ipRecord.Location.Latitude
-			pinput["_ip_longitude"] = ipRecord.Location.Longitude
-			pinput["_ip_accuracy_radius"] = ipRecord.Location.AccuracyRadius
-
-			//get state from city name
-			cs, exists := cityState[strings.ToLower(ipRecord.Country.IsoCode)]
-			if exists {
-				state, exists := cs[strings.ToLower(ipRecord.City.Names["en"])]
-				if exists {
-					pinput["_ip_state"] = state
-				}
-			}
+		if contentType != "application/json" {
+			registryMutex.RLock()
+			coerceInput(groupName, pinput)
+			registryMutex.RUnlock()
 		}
 	}
 
-	logrus.Debugf("input=%s", pinput)
-
-	defaultKeepFirst, exists := groupKeepFirst[groupName]
-	if !exists {
-		defaultKeepFirst = true
-	}
-	keepFirst, err := getBool(pinput, "_keepFirst", defaultKeepFirst)
-	if err != nil {
-		logrus.Warnf(err.Error())
-		http.Error(w, "Error processing rules", 500)
+	poutput, err := evaluateRequest(r, groupName, pinput)
+	if retryAfter, limited := retryAfterSeconds(err); limited {
+		w.Header().Set("Retry-After", retryAfter)
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
 		return
 	}
-
-	defaultFlatten, exists := groupFlatten[groupName]
-	if !exists {
-		defaultFlatten = false
-	}
-	flatten, err := getBool(pinput, "_flatten", defaultFlatten)
-	if err != nil {
-		logrus.Warnf(err.Error())
-		http.Error(w, "Error processing rules", 500)
-		return
-	}
-
-	info, err := getBool(pinput, "_info", true)
-	if err != nil {
-		logrus.Warnf(err.Error())
-		http.Error(w, "Error processing rules", 500)
-		return
-	}
-
-	logrus.Debugf("Calling request filter")
-	err = requestFilter(r, pinput)
 	if err != nil {
 		logrus.Warnf("Error processing rules. err=%s", err)
-		http.Error(w, "Error processing rules", 500)
+		http.Error(w, fmt.Sprintf("Error processing rules: %s", err), errorStatus(err))
+		return
 	}
 
-	poutput, err := Process(groupName, pinput, ProcessOptions{MergeKeepFirst: keepFirst, FlattenOutput: flatten, AddRuleInfo: info})
+	logrus.Debugf("Encoding output map to %s. output=%s", mediaType, poutput)
+	w.Header().Set("Content-Type", encoder.ContentType())
+	outBytes, err := encoder.Encode(poutput)
 	if err != nil {
-		logrus.Warnf("Error processing rules. err=%s", err)
-		http.Error(w, fmt.Sprintf("Error processing rules: %s", err), 500)
+		logrus.Warnf("Error encoding output. err=%s", err)
+		http.Error(w, "Error encoding output", 500)
 		return
 	}
 
-	logrus.Debugf("Parsing output map to json. output=%s", poutput)
-	w.Header().Set("Content-Type", "application/json")
-	outBytes, err := json.Marshal(poutput)
-
 	logrus.Debugf("Calling response filter")
-	interrupt, err1 := responseFilter(w, pinput, poutput, outBytes)
+	interrupt, err1 := responseFilter(w, pinput, poutput, outBytes, mediaType)
 	if err1 != nil {
 		logrus.Warnf("Error processing rules. err=%s", err1)
 		http.Error(w, "Error processing rules", 500)
@@ -560,6 +666,13 @@ func HandleRuleGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	etag := fmt.Sprintf("\"%x\"", sha1.Sum(outBytes))
+	w.Header().Set("ETag", etag)
+	if strings.Contains(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
 	_, err1 = w.Write(outBytes)
 	if err1 != nil {
 		logrus.Warnf("Error writing response. err=%s", err1)
@@ -581,3 +694,152 @@ func getBool(vmap map[string]interface{}, vkey string, defaultValue bool) (bool,
 	}
 	return value, nil
 }
+
+//evaluateRequest enriches the input of a request, calls the request filter and processes the rules of a group.
+//Shared by all APIs so that they behave the same way
+func evaluateRequest(r *http.Request, groupName string, pinput map[string]interface{}) (map[string]interface{}, error) {
+	return evaluateRequestLimited(r, groupName, pinput, true)
+}
+
+//evaluateRequestLimited evaluates a request as evaluateRequest does. Request limits (see SetRateLimit and SetConcurrencyLimit) are skipped if 'limit' is false,
+//which is used to re-evaluate the request of an already admitted watch when its group changes
+func evaluateRequestLimited(r *http.Request, groupName string, pinput map[string]interface{}, limit bool) (map[string]interface{}, error) {
+	//checked before anything keeps state by group name, such as limiters
+	registryMutex.RLock()
+	_, exists := groupRules[groupName]
+	registryMutex.RUnlock()
+	if !exists {
+		return nil, notFoundError{fmt.Errorf("Group %s doesn't exist", groupName)}
+	}
+
+	if limit {
+		release, err := admitRequest(r, groupName)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+
+	err := checkInputLimits(pinput)
+	if err != nil {
+		return nil, badRequest(err)
+	}
+
+	enrichInput(r, groupName, pinput)
+	err = enrich(r.Context(), r, groupName, pinput)
+	if err != nil {
+		return nil, err
+	}
+	logrus.Debugf("input=%s", pinput)
+
+	registryMutex.RLock()
+	options, err := processOptions(groupName, pinput)
+	registryMutex.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.Debugf("Calling request filter")
+	err = requestFilter(r, pinput)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit {
+		err = admitInput(r, groupName, pinput)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return Process(groupName, pinput, options)
+}
+
+//enrichInput adds the client IP, its certificate identity and the authenticated principal to input. Other attributes are added by enrichers (see AddEnricher)
+func enrichInput(r *http.Request, groupName string, pinput map[string]interface{}) {
+	//clients can't send attributes enrichers would provide, even when enrichers are skipped
+	stripEnrichedInputs(groupName, pinput)
+	clientIdentity(r, pinput)
+	principalInput(r, pinput)
+	ipStr, chain := clientIP(r)
+	if ipStr == "" {
+		ipStr = "0.0.0.0"
+	}
+	ipChain := make([]interface{}, len(chain))
+	for i, addr := range chain {
+		ipChain[i] = addr
+	}
+	pinput["_remote_ip"] = ipStr
+	pinput["_ip_chain"] = ipChain
+}
+
+//processOptions reads the special attributes from input, using group defaults for the missing ones
+func processOptions(groupName string, pinput map[string]interface{}) (ProcessOptions, error) {
+	defaultKeepFirst, exists := groupKeepFirst[groupName]
+	if !exists {
+		defaultKeepFirst = true
+	}
+	keepFirst, err := getBool(pinput, "_keepFirst", defaultKeepFirst)
+	if err != nil {
+		return ProcessOptions{}, badRequest(err)
+	}
+
+	defaultFlatten, exists := groupFlatten[groupName]
+	if !exists {
+		defaultFlatten = false
+	}
+	flatten, err := getBool(pinput, "_flatten", defaultFlatten)
+	if err != nil {
+		return ProcessOptions{}, badRequest(err)
+	}
+
+	treeOutput, err := getBool(pinput, "_treeOutput", groupTreeOutput[groupName])
+	if err != nil {
+		return ProcessOptions{}, badRequest(err)
+	}
+
+	info, err := getBool(pinput, "_info", true)
+	if err != nil {
+		return ProcessOptions{}, badRequest(err)
+	}
+
+	explain, err := getBool(pinput, "_explain", false)
+	if err != nil {
+		return ProcessOptions{}, badRequest(err)
+	}
+
+	fields, err := getFields(pinput, "_fields")
+	if err != nil {
+		return ProcessOptions{}, badRequest(err)
+	}
+
+	return ProcessOptions{MergeKeepFirst: keepFirst, FlattenOutput: flatten, AddRuleInfo: info, Explain: explain, TreeOutput: treeOutput, Fields: fields}, nil
+}
+
+//requestError an error caused by the request itself
+type requestError struct {
+	error
+}
+
+func badRequest(err error) error {
+	return requestError{err}
+}
+
+//notFoundError an error caused by a request to a group (or rule) that doesn't exist
+type notFoundError struct {
+	error
+}
+
+//errorStatus HTTP status for an error returned by evaluateRequest
+func errorStatus(err error) int {
+	if _, ok := err.(requestError); ok {
+		return http.StatusBadRequest
+	}
+	if _, ok := err.(limitError); ok {
+		return http.StatusTooManyRequests
+	}
+	if _, ok := err.(notFoundError); ok {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
@@ -45,7 +45,7 @@ func TestRullerFunction(t *testing.T) {
 		return nil
 	})
 
-	SetResponseFilter(func(w http.ResponseWriter, input map[string]interface{}, output map[string]interface{}, outBytes []byte) (bool, error) {
+	SetResponseFilter(func(w http.ResponseWriter, input map[string]interface{}, output map[string]interface{}, outBytes []byte, mediaType string) (bool, error) {
 		logrus.Debugf("filtering response. input=%s", input)
 		output["filter-attribute"] = "added by sample filter"
 		if input["_something"] == "test" {
@@ -79,4 +79,53 @@ func TestRullerFunction(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, w.Code)
 	assert.Equal(t, []byte(`{"a":{"_items":[{"opt1":"Some tests rule 1","rule1":true,"rule1-opt2":129.99}]}}`), w.Body.Bytes())
+}
+
+func TestTreeOutput(t *testing.T) {
+	Add("tree", "rule1", func(ctx Context) (map[string]interface{}, error) {
+		return map[string]interface{}{"opt1": "rule1"}, nil
+	})
+	AddChild("tree", "rule1.1", "rule1", func(ctx Context) (map[string]interface{}, error) {
+		return map[string]interface{}{"opt2": "rule1.1"}, nil
+	})
+
+	output, err := Process("tree", map[string]interface{}{}, ProcessOptions{AddRuleInfo: true, TreeOutput: true})
+	assert.Nil(t, err)
+	outBytes, _ := json.Marshal(output)
+	assert.JSONEq(t, `{"rule1":{"_rule":"rule1","output":{"opt1":"rule1"},"children":{"rule1.1":{"_rule":"rule1.1","output":{"opt2":"rule1.1"}}}}}`, string(outBytes))
+
+	output, err = Process("tree", map[string]interface{}{}, ProcessOptions{AddRuleInfo: true})
+	assert.Nil(t, err)
+	outBytes, _ = json.Marshal(output)
+	assert.JSONEq(t, `{"_items":[{"opt1":"rule1","_items":[{"opt2":"rule1.1"}]}]}`, string(outBytes))
+
+	output, err = Process("tree", map[string]interface{}{}, ProcessOptions{AddRuleInfo: true, FlattenOutput: true})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"_rule": "rule1.1", "opt1": "rule1", "opt2": "rule1.1"}, output)
+
+	output, err = Process("tree", map[string]interface{}{}, ProcessOptions{AddRuleInfo: true, FlattenOutput: true, TreeOutput: true})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"opt1": "rule1", "opt2": "rule1.1"}, output)
+
+	//parents without output keep the outputs of their children
+	Add("tree", "rule2", func(ctx Context) (map[string]interface{}, error) {
+		return nil, nil
+	})
+	AddChild("tree", "rule2.1", "rule2", func(ctx Context) (map[string]interface{}, error) {
+		return map[string]interface{}{"opt3": "rule2.1"}, nil
+	})
+	output, err = Process("tree", map[string]interface{}{}, ProcessOptions{TreeOutput: true})
+	assert.Nil(t, err)
+	outBytes, _ = json.Marshal(output)
+	assert.JSONEq(t, `{"rule1":{"output":{"opt1":"rule1"},"children":{"rule1.1":{"output":{"opt2":"rule1.1"}}}},"rule2":{"output":{},"children":{"rule2.1":{"output":{"opt3":"rule2.1"}}}}}`, string(outBytes))
+
+	//fields are selected in the output of each node
+	output, err = Process("tree", map[string]interface{}{}, ProcessOptions{TreeOutput: true, Fields: []string{"opt2", "opt3"}})
+	assert.Nil(t, err)
+	outBytes, _ = json.Marshal(output)
+	assert.JSONEq(t, `{"rule1":{"output":{},"children":{"rule1.1":{"output":{"opt2":"rule1.1"}}}},"rule2":{"output":{},"children":{"rule2.1":{"output":{"opt3":"rule2.1"}}}}}`, string(outBytes))
+	output, err = Process("tree", map[string]interface{}{}, ProcessOptions{TreeOutput: true, Fields: []string{"opt1"}})
+	assert.Nil(t, err)
+	outBytes, _ = json.Marshal(output)
+	assert.JSONEq(t, `{"rule1":{"output":{"opt1":"rule1"}}}`, string(outBytes))
 }
\ No newline at end of file
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"math/rand"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/flaviostutz/ruller"
 	"github.com/sirupsen/logrus"
@@ -80,7 +86,7 @@ func main() {
 		return nil
 	})
 
-	ruller.SetResponseFilter(func(w http.ResponseWriter, input map[string]interface{}, output map[string]interface{}, outBytes []byte) (bool, error) {
+	ruller.SetResponseFilter(func(w http.ResponseWriter, input map[string]interface{}, output map[string]interface{}, outBytes []byte, mediaType string) (bool, error) {
 		logrus.Debugf("filtering response. input=%s", input)
 		output["filter-attribute"] = "added by sample filter"
 		if input["_something"] == "test" {
@@ -132,7 +138,32 @@ func main() {
 		}
 	}
 
-	if err := ruller.StartServer(); err != nil {
+	cfg, err := ruller.LoadServerConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		logrus.Errorf("invalid configuration %s", err.Error())
+		os.Exit(2)
+	}
+	server := ruller.NewServer(cfg)
+	server.OnShutdown(func(ctx context.Context) error {
+		logrus.Infof("====Ruller Sample stopped====")
+		return nil
+	})
+
+	//drain in-flight requests on SIGTERM (ex.: during rolling deployments)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-signals
+		logrus.Infof("Received %s. Shutting down", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+		err := server.Shutdown(ctx)
+		if err != nil {
+			logrus.Warnf("error shutting down server %s", err.Error())
+		}
+	}()
+
+	if err := server.Start(); err != nil {
 		logrus.Errorf("error starting server %s", err.Error())
 	}
 }
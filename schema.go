@@ -0,0 +1,127 @@
+package ruller
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+//OutputValidation what to do when a merged output doesn't match the declared output schema of its group
+type OutputValidation int
+
+const (
+	//ValidationLog log violations and return the output as is. This is the default
+	ValidationLog OutputValidation = iota
+	//ValidationDrop remove attributes with violations from output
+	ValidationDrop
+	//ValidationReject fail processing
+	ValidationReject
+)
+
+type outputDeclaration struct {
+	outputType InputType
+	values     []string
+}
+
+var (
+	outputSchemas    = make(map[string]map[string]outputDeclaration) //[group][output name]
+	outputValidation = make(map[string]OutputValidation)
+)
+
+var outputViolationCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "ruller_output_schema_violations_total",
+	Help: "Number of output attributes that didn't match the declared output schema of their group",
+}, []string{
+	"group",
+	"output",
+})
+
+//DeclareOutput declares the type of an output attribute of a group. When values are defined, the output must be a string among them (enum).
+//When a group has declared outputs, merged flatten outputs are validated against them (see SetOutputValidation). Undeclared attributes are not validated
+func DeclareOutput(groupName string, outputName string, ot InputType, values ...string) {
+	logrus.Debugf("Declaring output. group=%s. attribute=%s", groupName, outputName)
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	gs, exists := outputSchemas[groupName]
+	if !exists {
+		gs = make(map[string]outputDeclaration)
+		outputSchemas[groupName] = gs
+	}
+	gs[outputName] = outputDeclaration{outputType: ot, values: values}
+}
+
+//SetOutputValidation sets what to do with outputs that don't match the declared output schema of a group
+func SetOutputValidation(groupName string, validation OutputValidation) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	outputValidation[groupName] = validation
+}
+
+//validateOutput checks declared output attributes, dropping them or returning an error according to the group validation mode
+func validateOutput(groupName string, output map[string]interface{}) error {
+	gs, exists := outputSchemas[groupName]
+	if !exists {
+		return nil
+	}
+	violations := make([]string, 0)
+	for outputName, decl := range gs {
+		v, exists := output[outputName]
+		if !exists {
+			continue
+		}
+		if !matchesType(v, decl.outputType) {
+			violations = append(violations, fmt.Sprintf("%s must be of type %s", outputName, decl.outputType))
+		} else if len(decl.values) > 0 && !containsString(decl.values, v) {
+			violations = append(violations, fmt.Sprintf("%s must be one of %s", outputName, strings.Join(decl.values, ",")))
+		} else {
+			continue
+		}
+		outputViolationCount.WithLabelValues(groupName, outputName).Inc()
+		if outputValidation[groupName] == ValidationDrop {
+			delete(output, outputName)
+		}
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	sort.Strings(violations)
+	msg := strings.Join(violations, "; ")
+	if outputValidation[groupName] == ValidationReject {
+		return badRequest(fmt.Errorf("Output attributes don't match declared schema: %s", msg))
+	}
+	logrus.Warnf("Output attributes of group %s don't match declared schema: %s", groupName, msg)
+	return nil
+}
+
+func matchesType(v interface{}, t InputType) bool {
+	switch t {
+	case String:
+		_, ok := v.(string)
+		return ok
+	case Float64:
+		_, ok := toFloat64(v)
+		return ok
+	case Bool:
+		_, ok := v.(bool)
+		return ok
+	case Object:
+		_, ok := v.(map[string]interface{})
+		return ok
+	case Array:
+		return v != nil && reflect.TypeOf(v).Kind() == reflect.Slice
+	}
+	return false
+}
+
+func containsString(values []string, v interface{}) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
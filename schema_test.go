@@ -0,0 +1,56 @@
+package ruller
+
+import (
+	"go/parser"
+	"go/token"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutputSchema(t *testing.T) {
+	Add("schema", "rule1", func(ctx Context) (map[string]interface{}, error) {
+		return map[string]interface{}{"showBanner": "true", "theme": "dark", "count": 3}, nil
+	})
+	DeclareOutput("schema", "showBanner", Bool)
+	DeclareOutput("schema", "theme", String, "light", "dark")
+	DeclareOutput("schema", "count", Float64)
+
+	options := ProcessOptions{FlattenOutput: true, MergeKeepFirst: true}
+	output, err := Process("schema", map[string]interface{}{}, options)
+	assert.Nil(t, err)
+	assert.Equal(t, "true", output["showBanner"])
+
+	SetOutputValidation("schema", ValidationDrop)
+	output, err = Process("schema", map[string]interface{}{}, options)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"theme": "dark", "count": 3}, output)
+
+	SetOutputValidation("schema", ValidationReject)
+	_, err = Process("schema", map[string]interface{}{}, options)
+	assert.NotNil(t, err)
+
+	desc, err := DescribeGroup("schema")
+	assert.Nil(t, err)
+	assert.Equal(t, OutputDescription{Type: "string", Values: []string{"light", "dark"}}, desc.Outputs["theme"])
+
+	src, err := GenerateGoClient("schemaclient", "schema")
+	assert.Nil(t, err)
+	_, err = parser.ParseFile(token.NewFileSet(), "client.go", src, 0)
+	assert.Nil(t, err)
+	assert.Contains(t, string(src), "ShowBanner *bool")
+	assert.Contains(t, string(src), `SchemaThemeDark = "dark"`)
+	_, err = GenerateGoClient("schemaclient", "unknown")
+	assert.NotNil(t, err)
+
+	DeclareOutput("schema-values", "label", String, `say "hi"`, `C:\tmp`, "a-b", "a b", "1st")
+	src, err = GenerateGoClient("schemaclient", "schema-values")
+	assert.Nil(t, err)
+	_, err = parser.ParseFile(token.NewFileSet(), "client.go", src, 0)
+	assert.Nil(t, err)
+	assert.Contains(t, string(src), `SchemaValuesLabelSayHi = "say \"hi\""`)
+	assert.Contains(t, string(src), `SchemaValuesLabelCTmp = "C:\\tmp"`)
+	assert.Contains(t, string(src), `SchemaValuesLabelAB = "a-b"`)
+	assert.Contains(t, string(src), `SchemaValuesLabelAB2 = "a b"`)
+	assert.Contains(t, string(src), `SchemaValuesLabelX1st = "1st"`)
+}
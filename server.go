@@ -0,0 +1,602 @@
+package ruller
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/oschwald/geoip2-golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+//StartHook called when a server starts, after its resources are loaded and before it starts listening. An error aborts the start
+type StartHook func() error
+
+//ShutdownHook called when a server shuts down, after in-flight requests are drained. Use it to release resources used by rules
+type ShutdownHook func(ctx context.Context) error
+
+//shutdownTimeout max time to drain in-flight requests when the context of StartServerWithConfig is done
+var shutdownTimeout = 30 * time.Second
+
+var (
+	startHooks          = make([]StartHook, 0)
+	shutdownHooks       = make([]ShutdownHook, 0)
+	registerMetricsOnce sync.Once
+)
+
+//OnStart registers a hook called by every server on start
+func OnStart(hook StartHook) {
+	startHooks = append(startHooks, hook)
+}
+
+//OnShutdown registers a hook called by every server on shutdown
+func OnShutdown(hook ShutdownHook) {
+	shutdownHooks = append(shutdownHooks, hook)
+}
+
+//Server the built-in REST (and gRPC) server. A server can't be started again after being shut down
+type Server struct {
+	config        ServerConfig
+	startHooks    []StartHook
+	shutdownHooks []ShutdownHook
+
+	mutex      sync.Mutex
+	httpServer *http.Server
+	grpcServer *grpc.Server
+	wsConns    map[*websocket.Conn]bool
+	//newConns connections accepted that didn't send a request yet, with their accept time
+	newConns map[net.Conn]time.Time
+	//handlers requests being handled by the REST and gRPC APIs
+	handlers sync.WaitGroup
+	//streams gRPC streams being handled (watches), cancelled on shutdown as they only end when the client leaves
+	streams map[*trackedStream]bool
+	shutdown bool
+	//serving true while listening and not shutting down
+	serving bool
+	//stopped closed when Shutdown finishes
+	stopped chan struct{}
+}
+
+//NewServer creates a server. Nothing is loaded until Start is called
+func NewServer(cfg ServerConfig) *Server {
+	return &Server{
+		config:   cfg,
+		wsConns:  make(map[*websocket.Conn]bool),
+		newConns: make(map[net.Conn]time.Time),
+		streams:  make(map[*trackedStream]bool),
+		stopped:  make(chan struct{}),
+	}
+}
+
+//OnStart registers a hook called when this server starts, after the hooks registered with the package OnStart
+func (s *Server) OnStart(hook StartHook) {
+	s.startHooks = append(s.startHooks, hook)
+}
+
+//OnShutdown registers a hook called when this server shuts down, before the hooks registered with the package OnShutdown
+func (s *Server) OnShutdown(hook ShutdownHook) {
+	s.shutdownHooks = append(s.shutdownHooks, hook)
+}
+
+//Start loads resources (rule states, GeoIP databases), calls start hooks and serves requests.
+//Blocks until serving fails or Shutdown finishes. Returns nil after Shutdown
+func (s *Server) Start() error {
+	cfg := s.config
+	err := SetCORSPolicy(CORSPolicy{
+		AllowedOrigins:   splitList(cfg.Origins),
+		AllowedMethods:   splitList(cfg.AllowMethods),
+		AllowedHeaders:   splitList(cfg.AllowHeaders),
+		ExposedHeaders:   splitList(cfg.ExposeHeaders),
+		AllowCredentials: cfg.AllowCredentials,
+		MaxAge:           time.Duration(cfg.CORSMaxAge) * time.Second,
+		Routes:           splitList(cfg.CORSRoutes),
+	})
+	if err != nil {
+		return err
+	}
+
+	switch cfg.LogLevel {
+	case "debug":
+		logrus.SetLevel(logrus.DebugLevel)
+	case "warning":
+		logrus.SetLevel(logrus.WarnLevel)
+	case "error":
+		logrus.SetLevel(logrus.ErrorLevel)
+	default:
+		logrus.SetLevel(logrus.InfoLevel)
+	}
+
+	registerMetricsOnce.Do(func() {
+		prometheus.MustRegister(rulesProcessingHist)
+		prometheus.MustRegister(groupRuleCount)
+		prometheus.MustRegister(ruleEnabledGauge)
+		prometheus.MustRegister(outputViolationCount)
+		prometheus.MustRegister(limiterDecisions)
+	})
+
+	err = SetTrustedProxies(splitList(cfg.TrustedProxies)...)
+	if err != nil {
+		return err
+	}
+	err = SetForwardedHeader(cfg.ForwardedHeader)
+	if err != nil {
+		return err
+	}
+
+	SetInputLimits(InputLimits{
+		MaxBodySize:        cfg.MaxBodySize,
+		MaxDepth:           cfg.MaxInputDepth,
+		MaxKeys:            cfg.MaxInputKeys,
+		AllowDuplicateKeys: cfg.AllowDuplicateKeys,
+		UseNumber:          cfg.JSONUseNumber,
+	})
+
+	if cfg.RateLimit > 0 {
+		err := SetRateLimit("*", RateLimit{Key: cfg.RateLimitKey, Rate: cfg.RateLimit, Burst: cfg.RateLimitBurst})
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.MaxConcurrentEvaluations > 0 {
+		SetConcurrencyLimit("*", cfg.MaxConcurrentEvaluations)
+	}
+
+	if cfg.RuleStateFile != "" {
+		err := LoadRuleStates(cfg.RuleStateFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	tlsConfig, err := NewTLSConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	err = configureAuth(cfg)
+	if err != nil {
+		return err
+	}
+
+	err = loadGeoDatabases(cfg)
+	if err != nil {
+		return err
+	}
+
+	for _, hook := range append(append([]StartHook{}, startHooks...), s.startHooks...) {
+		err := hook()
+		if err != nil {
+			closeGeoDatabases()
+			return err
+		}
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/rules/{groupName}", HandleRuleGroup).Methods("POST")
+	router.HandleFunc("/rules/{groupName}", HandleDescribeGroup).Methods("GET")
+	router.HandleFunc("/rules/{groupName}/bundle", HandleExportBundle).Methods("GET")
+	router.HandleFunc("/openapi.json", HandleOpenAPI).Methods("GET")
+	router.HandleFunc("/admin/rules/{groupName}/{ruleName}/enabled", HandleRuleEnabled).Methods("GET", "PUT")
+	router.HandleFunc("/admin/overrides/{groupName}", HandleOverrides).Methods("GET")
+	router.HandleFunc("/admin/overrides/{groupName}/{subjectKey}/{subjectValue}", HandleOverrides).Methods("GET", "PUT", "DELETE")
+	router.Handle("/metrics", promhttp.Handler())
+	router.HandleFunc("/healthz", HandleHealth).Methods("GET")
+	router.HandleFunc("/readyz", s.handleReady).Methods("GET")
+	router.HandleFunc("/info", HandleInfo).Methods("GET")
+	for _, path := range corsPolicy.Routes {
+		//preflight requests are answered by Middleware
+		router.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {}).Methods("OPTIONS")
+	}
+	router.Use(s.trackHandlers)
+	router.Use(Middleware)
+	router.Use(AuthMiddleware)
+
+	if cfg.WS {
+		router.HandleFunc("/ws", s.handleWS)
+	}
+
+	s.mutex.Lock()
+	if s.shutdown {
+		s.mutex.Unlock()
+		return fmt.Errorf("Server was shut down")
+	}
+	listen := fmt.Sprintf("%s:%d", cfg.ListenAddress, cfg.ListenPort)
+	s.httpServer = &http.Server{Addr: listen, Handler: router, TLSConfig: tlsConfig, ConnState: s.trackConn}
+	if cfg.GRPCPort != 0 {
+		grpcListen := fmt.Sprintf("%s:%d", cfg.ListenAddress, cfg.GRPCPort)
+		lis, err := net.Listen("tcp", grpcListen)
+		if err != nil {
+			s.mutex.Unlock()
+			return err
+		}
+		logrus.Infof("gRPC listening at %s", grpcListen)
+		opts := []grpc.ServerOption{grpc.UnaryInterceptor(s.trackGRPCHandlers), grpc.StreamInterceptor(s.trackGRPCStreams)}
+		if tlsConfig != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		}
+		s.grpcServer = NewGRPCServer(opts...)
+		go func() {
+			err := s.grpcServer.Serve(lis)
+			if err != nil {
+				logrus.Errorf("gRPC server stopped. err=%s", err)
+			}
+		}()
+	}
+	s.serving = true
+	s.mutex.Unlock()
+
+	if tlsConfig != nil {
+		logrus.Infof("Listening at %s with TLS", listen)
+		err = s.httpServer.ListenAndServeTLS("", "")
+	} else {
+		logrus.Infof("Listening at %s", listen)
+		err = s.httpServer.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
+		if s.grpcServer != nil {
+			s.grpcServer.Stop()
+		}
+		closeGeoDatabases()
+		return err
+	}
+	<-s.stopped
+	return nil
+}
+
+//Shutdown stops accepting requests, closes websockets with a close frame and waits for in-flight requests to finish (or ctx to be done).
+//Then calls shutdown hooks and releases GeoIP databases. If ctx is done before, GeoIP databases are only released after the remaining requests finish
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	if s.shutdown {
+		s.mutex.Unlock()
+		<-s.stopped
+		return nil
+	}
+	s.shutdown = true
+	s.serving = false
+	httpServer := s.httpServer
+	grpcServer := s.grpcServer
+	wsConns := make([]*websocket.Conn, 0, len(s.wsConns))
+	for c := range s.wsConns {
+		wsConns = append(wsConns, c)
+	}
+	for stream := range s.streams {
+		stream.cancel()
+	}
+	s.mutex.Unlock()
+	defer close(s.stopped)
+	logrus.Infof("Shutting down server")
+
+	var result error
+	for _, c := range wsConns {
+		err := c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"), time.Now().Add(time.Second))
+		if err != nil {
+			logrus.Debugf("Error sending ws close frame. err=%s", err)
+		}
+		c.Close()
+	}
+	if httpServer != nil {
+		drained := make(chan struct{})
+		go s.closeNewConns(drained)
+		err := httpServer.Shutdown(ctx)
+		close(drained)
+		if err != nil {
+			result = err
+		}
+	}
+	if grpcServer != nil {
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			grpcServer.Stop()
+		}
+	}
+
+	handled := make(chan struct{})
+	go func() {
+		s.handlers.Wait()
+		close(handled)
+	}()
+	drained := true
+	select {
+	case <-handled:
+	case <-ctx.Done():
+		logrus.Warnf("Requests still being handled after shutdown timeout")
+		drained = false
+		if result == nil {
+			result = ctx.Err()
+		}
+	}
+
+	for _, hook := range append(append([]ShutdownHook{}, s.shutdownHooks...), shutdownHooks...) {
+		err := hook(ctx)
+		if err != nil {
+			logrus.Warnf("Shutdown hook failed. err=%s", err)
+			if result == nil {
+				result = err
+			}
+		}
+	}
+	if drained {
+		closeGeoDatabases()
+	} else {
+		go func() {
+			<-handled
+			closeGeoDatabases()
+		}()
+	}
+	logrus.Infof("Server stopped")
+	return result
+}
+
+//trackHandlers tracks REST requests, so that resources they use are only released after they finish
+func (s *Server) trackHandlers(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.handlers.Add(1)
+		defer s.handlers.Done()
+		next.ServeHTTP(w, r)
+	})
+}
+
+//trackGRPCHandlers tracks gRPC calls, as Stop (used when ctx is done) doesn't wait for them
+func (s *Server) trackGRPCHandlers(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	s.handlers.Add(1)
+	defer s.handlers.Done()
+	return handler(ctx, req)
+}
+
+//trackedStream a gRPC stream whose context is cancelled on shutdown
+type trackedStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (t *trackedStream) Context() context.Context {
+	return t.ctx
+}
+
+//trackGRPCStreams tracks gRPC streams as trackGRPCHandlers does for calls. Streams are cancelled on shutdown so that GracefulStop doesn't wait for watches
+func (s *Server) trackGRPCStreams(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	s.handlers.Add(1)
+	defer s.handlers.Done()
+	ctx, cancel := context.WithCancel(ss.Context())
+	stream := &trackedStream{ServerStream: ss, ctx: ctx, cancel: cancel}
+	defer cancel()
+	s.mutex.Lock()
+	if s.shutdown {
+		cancel()
+	} else {
+		s.streams[stream] = true
+	}
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.streams, stream)
+		s.mutex.Unlock()
+	}()
+	return handler(srv, stream)
+}
+
+func (s *Server) trackConn(c net.Conn, state http.ConnState) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if state == http.StateNew {
+		s.newConns[c] = time.Now()
+	} else {
+		delete(s.newConns, c)
+	}
+}
+
+//closeNewConns closes connections that were accepted but didn't send a request for a while, until drained is closed.
+//http.Server.Shutdown would wait 5 seconds for each of them
+func (s *Server) closeNewConns(drained chan struct{}) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.mutex.Lock()
+			for c, accepted := range s.newConns {
+				if time.Since(accepted) > 500*time.Millisecond {
+					c.Close()
+					delete(s.newConns, c)
+				}
+			}
+			s.mutex.Unlock()
+		case <-drained:
+			return
+		}
+	}
+}
+
+//handleReady runs the registered readiness checks along with the checks of the resources of this server (GET /readyz)
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	checks, ready := Readiness()
+	s.mutex.Lock()
+	serving := s.serving
+	s.mutex.Unlock()
+	serverChecks := map[string]ReadinessCheck{
+		"server": func() error {
+			if !serving {
+				return fmt.Errorf("Server is not serving requests")
+			}
+			return nil
+		},
+	}
+	if s.config.GeoLite2DB != "" {
+		serverChecks["geolite2-db"] = func() error {
+			if geodb == nil {
+				return fmt.Errorf("GeoIP2 database %s not loaded", s.config.GeoLite2DB)
+			}
+			return nil
+		}
+		if s.config.CityStateDB != "" {
+			serverChecks["city-state-db"] = func() error {
+				if len(cityState) == 0 {
+					return fmt.Errorf("City State database %s not loaded", s.config.CityStateDB)
+				}
+				return nil
+			}
+		}
+	}
+	results, serverReady := runChecks(serverChecks)
+	for name, result := range results {
+		checks[name] = result
+	}
+	writeReadiness(w, checks, ready && serverReady)
+}
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+} // use default options
+
+//handleWS dummy websocket. Clients detect server restarts when it is closed
+func (s *Server) handleWS(w http.ResponseWriter, r *http.Request) {
+	c, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logrus.Warnf("ws upgrade err: %s", err)
+		return
+	}
+	s.mutex.Lock()
+	if s.shutdown {
+		s.mutex.Unlock()
+		c.Close()
+		return
+	}
+	s.wsConns[c] = true
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.wsConns, c)
+		s.mutex.Unlock()
+		c.Close()
+	}()
+	c.SetReadLimit(10)
+	for {
+		_, _, err := c.ReadMessage()
+		if err != nil {
+			logrus.Debugf("ws read err: %s", err)
+			return
+		}
+	}
+}
+
+//configureAuth sets the authenticators and the authorization policy defined in cfg. Authenticators set from Go with SetAuthenticators are kept if cfg defines none
+func configureAuth(cfg ServerConfig) error {
+	auths := make([]Authenticator, 0)
+	if cfg.AuthAPIKeysFile != "" {
+		a, err := LoadAPIKeys(cfg.AuthAPIKeysFile)
+		if err != nil {
+			return err
+		}
+		auths = append(auths, a)
+	}
+	if cfg.AuthHMACSecretsFile != "" {
+		a, err := LoadHMACSecrets(cfg.AuthHMACSecretsFile)
+		if err != nil {
+			return err
+		}
+		auths = append(auths, a)
+	}
+	if cfg.AuthJWKSFile != "" {
+		a, err := LoadJWKS(cfg.AuthJWKSFile)
+		if err != nil {
+			return err
+		}
+		a.Issuer = cfg.AuthJWTIssuer
+		a.Audience = cfg.AuthJWTAudience
+		auths = append(auths, a)
+	}
+	if cfg.AuthClientCert {
+		auths = append(auths, ClientCertAuthenticator{})
+	}
+	if len(auths) > 0 {
+		SetAuthenticators(auths...)
+	}
+	if cfg.AuthPolicyFile != "" {
+		policy, err := LoadAuthPolicy(cfg.AuthPolicyFile)
+		if err != nil {
+			return err
+		}
+		SetAuthPolicy(policy)
+	}
+	return nil
+}
+
+func loadGeoDatabases(cfg ServerConfig) error {
+	gf := cfg.GeoLite2DB
+	if gf == "" {
+		logrus.Infof("Geolite database file not found. Localization capabilities based on IP will be disabled")
+		return nil
+	}
+	logrus.Debugf("Loading GeoIP2 database %s", gf)
+	gdb, err := geoip2.Open(gf)
+	if err != nil {
+		return err
+	}
+	geodb = gdb
+	logrus.Infof("GeoIP2 database loaded")
+
+	cs := cfg.CityStateDB
+	if cs == "" {
+		logrus.Infof("City State csv file not defined. _ip_state input won't be available")
+		return nil
+	}
+	logrus.Debugf("Loading City State CSV file %s", cs)
+	csvFile, err := os.Open(cs)
+	if err != nil {
+		closeGeoDatabases()
+		return err
+	}
+	defer csvFile.Close()
+	reader := csv.NewReader(bufio.NewReader(csvFile))
+	for {
+		line, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			closeGeoDatabases()
+			return err
+		}
+		country := strings.ToLower(line[0])
+		city := strings.ToLower(line[1])
+		state := line[2]
+		cm, exists := cityState[country]
+		if !exists {
+			cm = make(map[string]string)
+			cityState[country] = cm
+		}
+		cm[city] = state
+	}
+	logrus.Infof("City State CSV loaded")
+	return nil
+}
+
+func closeGeoDatabases() {
+	if geodb != nil {
+		geodb.Close()
+		geodb = nil
+	}
+}
@@ -0,0 +1,180 @@
+package ruller
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	structpb "github.com/golang/protobuf/ptypes/struct"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+func TestServerShutdown(t *testing.T) {
+	evaluating := make(chan bool, 1)
+	Add("shutdown", "slow", func(ctx Context) (map[string]interface{}, error) {
+		evaluating <- true
+		time.Sleep(300 * time.Millisecond)
+		return map[string]interface{}{"done": true}, nil
+	})
+	lis, _ := net.Listen("tcp", "127.0.0.1:0")
+	port := lis.Addr().(*net.TCPAddr).Port
+	lis.Close()
+	cfg := DefaultServerConfig()
+	cfg.ListenAddress = "127.0.0.1"
+	cfg.ListenPort = port
+
+	server := NewServer(cfg)
+	started := false
+	shutdownCalled := false
+	server.OnStart(func() error {
+		started = true
+		return nil
+	})
+	server.OnShutdown(func(ctx context.Context) error {
+		shutdownCalled = true
+		return nil
+	})
+	done := make(chan error)
+	go func() {
+		done <- server.Start()
+	}()
+
+	var ws *websocket.Conn
+	var err error
+	for i := 0; i < 50; i++ {
+		ws, _, err = websocket.DefaultDialer.Dial(fmt.Sprintf("ws://127.0.0.1:%d/ws", port), nil)
+		if err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.Nil(t, err)
+	assert.True(t, started)
+	resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/readyz", port))
+	assert.Nil(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	resp.Body.Close()
+
+	slowStatus := make(chan int)
+	go func() {
+		resp, err := http.Post(fmt.Sprintf("http://127.0.0.1:%d/rules/shutdown", port), "application/json", strings.NewReader("{}"))
+		if err != nil {
+			slowStatus <- 0
+			return
+		}
+		resp.Body.Close()
+		slowStatus <- resp.StatusCode
+	}()
+	<-evaluating
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = server.Shutdown(ctx)
+	assert.Nil(t, err)
+	assert.True(t, shutdownCalled)
+
+	_, _, err = ws.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway))
+	assert.Equal(t, 200, <-slowStatus)
+	assert.Nil(t, <-done)
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	evaluating := make(chan bool, 1)
+	finished := make(chan bool, 1)
+	Add("shutdown-timeout", "slow", func(ctx Context) (map[string]interface{}, error) {
+		evaluating <- true
+		time.Sleep(300 * time.Millisecond)
+		finished <- true
+		return map[string]interface{}{"done": true}, nil
+	})
+	lis, _ := net.Listen("tcp", "127.0.0.1:0")
+	port := lis.Addr().(*net.TCPAddr).Port
+	lis.Close()
+	cfg := DefaultServerConfig()
+	cfg.ListenAddress = "127.0.0.1"
+	cfg.ListenPort = port
+
+	server := NewServer(cfg)
+	go server.Start()
+
+	slowStatus := make(chan int)
+	go func() {
+		for i := 0; i < 50; i++ {
+			resp, err := http.Post(fmt.Sprintf("http://127.0.0.1:%d/rules/shutdown-timeout", port), "application/json", strings.NewReader("{}"))
+			if err == nil {
+				resp.Body.Close()
+				slowStatus <- resp.StatusCode
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		slowStatus <- 0
+	}()
+	<-evaluating
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	hookFinished := false
+	server.OnShutdown(func(ctx context.Context) error {
+		select {
+		case hookFinished = <-finished:
+		default:
+		}
+		return nil
+	})
+	err := server.Shutdown(ctx)
+	assert.NotNil(t, err)
+	assert.False(t, hookFinished)
+	assert.Equal(t, 200, <-slowStatus)
+
+	server.handlers.Wait()
+}
+
+func TestServerShutdownGRPCWatch(t *testing.T) {
+	Add("shutdown-watch", "rule1", func(ctx Context) (map[string]interface{}, error) {
+		return map[string]interface{}{"done": true}, nil
+	})
+	lis, _ := net.Listen("tcp", "127.0.0.1:0")
+	port := lis.Addr().(*net.TCPAddr).Port
+	lis.Close()
+	lis, _ = net.Listen("tcp", "127.0.0.1:0")
+	grpcPort := lis.Addr().(*net.TCPAddr).Port
+	lis.Close()
+	cfg := DefaultServerConfig()
+	cfg.ListenAddress = "127.0.0.1"
+	cfg.ListenPort = port
+	cfg.GRPCPort = grpcPort
+
+	server := NewServer(cfg)
+	done := make(chan error)
+	go func() {
+		done <- server.Start()
+	}()
+
+	conn, err := grpc.Dial(fmt.Sprintf("127.0.0.1:%d", grpcPort), grpc.WithInsecure())
+	assert.Nil(t, err)
+	defer conn.Close()
+	req, _ := toStruct(map[string]interface{}{"group": "shutdown-watch"})
+	streamCtx, streamCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer streamCancel()
+	stream, err := conn.NewStream(streamCtx, &rullerServiceDesc.Streams[0], "/ruller.Ruller/WatchGroup", grpc.WaitForReady(true))
+	assert.Nil(t, err)
+	assert.Nil(t, stream.SendMsg(req))
+	err = stream.RecvMsg(new(structpb.Struct))
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	assert.Nil(t, server.Shutdown(ctx))
+	assert.True(t, time.Since(start) < time.Second, "watches must not hold graceful stop")
+	assert.NotNil(t, stream.RecvMsg(new(structpb.Struct)))
+	assert.Nil(t, <-done)
+}
@@ -0,0 +1,38 @@
+package ruller
+
+import (
+	"errors"
+	"fmt"
+)
+
+//EvaluationStrategy defines how a list of sibling rules (root rules of a group or children of a rule) is evaluated
+type EvaluationStrategy int
+
+const (
+	//EvaluateAll evaluate all rules and merge all outputs. This is the default
+	EvaluateAll EvaluationStrategy = iota
+	//EvaluateFirstMatch evaluate rules in order and stop at the first rule returning a non nil output. Useful for decision tables
+	EvaluateFirstMatch
+)
+
+//ErrStopProcessing may be returned by a rule (along with its output, which is kept) to skip the evaluation of its remaining siblings
+var ErrStopProcessing = errors.New("stop processing siblings")
+
+//SetGroupStrategy sets how the root rules of a group are evaluated
+func SetGroupStrategy(groupName string, strategy EvaluationStrategy) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	groupStrategy[groupName] = strategy
+}
+
+//SetChildrenStrategy sets how the children of a rule are evaluated
+func SetChildrenStrategy(groupName string, ruleName string, strategy EvaluationStrategy) error {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	rinfo, exists := rulesMap[groupName][ruleName]
+	if !exists {
+		return fmt.Errorf("Rule '%s' not found in group '%s'", ruleName, groupName)
+	}
+	rinfo.childrenStrategy = strategy
+	return nil
+}
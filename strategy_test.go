@@ -0,0 +1,38 @@
+package ruller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvaluationStrategies(t *testing.T) {
+	calls := make(map[string]int)
+	rule := func(name string, output map[string]interface{}, err error) Rule {
+		return func(ctx Context) (map[string]interface{}, error) {
+			calls[name]++
+			return output, err
+		}
+	}
+	Add("strategy", "young", rule("young", nil, nil))
+	Add("strategy", "adult", rule("adult", map[string]interface{}{"category": "adult"}, nil))
+	Add("strategy", "any", rule("any", map[string]interface{}{"category": "any", "other": true}, nil))
+	SetGroupStrategy("strategy", EvaluateFirstMatch)
+
+	options := ProcessOptions{FlattenOutput: true, MergeKeepFirst: true}
+	output, err := Process("strategy", map[string]interface{}{}, options)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"category": "adult"}, output)
+	assert.Equal(t, 0, calls["any"])
+
+	Add("strategy-stop", "parent", rule("parent", map[string]interface{}{"parent": true}, nil))
+	AddChild("strategy-stop", "c1", "parent", rule("c1", map[string]interface{}{"c1": true}, ErrStopProcessing))
+	AddChild("strategy-stop", "c2", "parent", rule("c2", map[string]interface{}{"c2": true}, nil))
+	assert.Nil(t, SetChildrenStrategy("strategy-stop", "parent", EvaluateAll))
+	assert.NotNil(t, SetChildrenStrategy("strategy-stop", "unknown", EvaluateAll))
+
+	output, err = Process("strategy-stop", map[string]interface{}{}, options)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"parent": true, "c1": true}, output)
+	assert.Equal(t, 0, calls["c2"])
+}
@@ -0,0 +1,139 @@
+package ruller
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+//certReloader serves the certificate of a cert/key file pair, reloading it whenever the files change so that renewed certificates are used without restarts
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mutex   sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	_, err := c.GetCertificate(nil)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+//GetCertificate returns the current certificate, reloading it if cert or key file changed. If reloading fails, the previous certificate is kept
+func (c *certReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	modTime, err := latestModTime(c.certFile, c.keyFile)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err != nil {
+		if c.cert != nil {
+			logrus.Warnf("Couldn't check TLS certificate files. Keeping current certificate. err=%s", err)
+			return c.cert, nil
+		}
+		return nil, err
+	}
+	if c.cert != nil && modTime.Equal(c.modTime) {
+		return c.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		if c.cert != nil {
+			logrus.Warnf("Couldn't reload TLS certificate %s. Keeping current certificate. err=%s", c.certFile, err)
+			return c.cert, nil
+		}
+		return nil, err
+	}
+	logrus.Infof("TLS certificate %s loaded", c.certFile)
+	c.cert = &cert
+	c.modTime = modTime
+	return c.cert, nil
+}
+
+func latestModTime(files ...string) (time.Time, error) {
+	var latest time.Time
+	for _, file := range files {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return latest, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+//NewTLSConfig creates the TLS configuration of a server. Returns nil if no certificate is configured.
+//When a client CA bundle is configured, client certificates are verified against it and required unless TLSClientOptional is set
+func NewTLSConfig(cfg ServerConfig) (*tls.Config, error) {
+	if cfg.TLSCert == "" && cfg.TLSKey == "" {
+		if cfg.TLSClientCA != "" {
+			return nil, fmt.Errorf("A TLS certificate is required to verify client certificates")
+		}
+		return nil, nil
+	}
+	if cfg.TLSCert == "" || cfg.TLSKey == "" {
+		return nil, fmt.Errorf("Both TLS certificate and key files must be defined")
+	}
+	reloader, err := newCertReloader(cfg.TLSCert, cfg.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+	if cfg.TLSClientCA != "" {
+		pem, err := ioutil.ReadFile(cfg.TLSClientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in client CA bundle %s", cfg.TLSClientCA)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		if cfg.TLSClientOptional {
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+	return tlsConfig, nil
+}
+
+//clientIdentity adds the identity of a verified client certificate to input ("_client_cn" and "_client_sans").
+//They are always set so that clients can't forge them in the request body
+func clientIdentity(r *http.Request, pinput map[string]interface{}) {
+	pinput["_client_cn"] = ""
+	sans := make([]interface{}, 0)
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		leaf := r.TLS.VerifiedChains[0][0]
+		pinput["_client_cn"] = leaf.Subject.CommonName
+		for _, name := range leaf.DNSNames {
+			sans = append(sans, name)
+		}
+		for _, email := range leaf.EmailAddresses {
+			sans = append(sans, email)
+		}
+		for _, ip := range leaf.IPAddresses {
+			sans = append(sans, ip.String())
+		}
+		for _, uri := range leaf.URIs {
+			sans = append(sans, uri.String())
+		}
+	}
+	pinput["_client_sans"] = sans
+}
@@ -0,0 +1,133 @@
+package ruller
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMutualTLS(t *testing.T) {
+	Add("tls", "rule1", func(ctx Context) (map[string]interface{}, error) {
+		return map[string]interface{}{"cn": ctx.Input["_client_cn"], "sans": ctx.Input["_client_sans"]}, nil
+	})
+	dir, _ := ioutil.TempDir("", "ruller-tls")
+	defer os.RemoveAll(dir)
+
+	caCert, caKey := newTestCert(t, "ca", nil, nil)
+	serverCert, serverKey := newTestCert(t, "server-1", caCert, caKey)
+	clientCert, clientKey := newTestCert(t, "orders-service", caCert, caKey)
+	writeTestPEM(t, filepath.Join(dir, "ca.pem"), caCert, nil)
+	writeTestPEM(t, filepath.Join(dir, "server.pem"), serverCert, nil)
+	writeTestPEM(t, filepath.Join(dir, "server.key"), nil, serverKey)
+
+	lis, _ := net.Listen("tcp", "127.0.0.1:0")
+	port := lis.Addr().(*net.TCPAddr).Port
+	lis.Close()
+	cfg := DefaultServerConfig()
+	cfg.ListenAddress = "127.0.0.1"
+	cfg.ListenPort = port
+	cfg.TLSCert = filepath.Join(dir, "server.pem")
+	cfg.TLSKey = filepath.Join(dir, "server.key")
+	cfg.TLSClientCA = filepath.Join(dir, "ca.pem")
+	server := NewServer(cfg)
+	go server.Start()
+	defer server.Shutdown(context.Background())
+
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+	clientTLS := &tls.Config{RootCAs: roots, ServerName: "localhost", Certificates: []tls.Certificate{{Certificate: [][]byte{clientCert.Raw}, PrivateKey: clientKey}}}
+	httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS, DisableKeepAlives: true}}
+	url := fmt.Sprintf("https://127.0.0.1:%d/rules/tls", port)
+
+	var resp *http.Response
+	var err error
+	for i := 0; i < 50; i++ {
+		resp, err = httpClient.Post(url, "application/json", strings.NewReader(`{"_flatten":true,"_client_cn":"forged"}`))
+		if err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.Nil(t, err)
+	output := make(map[string]interface{})
+	json.NewDecoder(resp.Body).Decode(&output)
+	resp.Body.Close()
+	assert.Equal(t, "orders-service", output["cn"])
+	assert.Equal(t, []interface{}{"orders-service.local"}, output["sans"])
+	assert.Equal(t, "server-1", resp.TLS.PeerCertificates[0].Subject.CommonName)
+
+	noCertClient := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, ServerName: "localhost"}}}
+	_, err = noCertClient.Post(url, "application/json", strings.NewReader(`{}`))
+	assert.NotNil(t, err)
+
+	//renewed certificate is used without restart
+	serverCert, serverKey = newTestCert(t, "server-2", caCert, caKey)
+	writeTestPEM(t, filepath.Join(dir, "server.pem"), serverCert, nil)
+	writeTestPEM(t, filepath.Join(dir, "server.key"), nil, serverKey)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(dir, "server.pem"), later, later)
+	resp, err = httpClient.Post(url, "application/json", strings.NewReader(`{}`))
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "server-2", resp.TLS.PeerCertificates[0].Subject.CommonName)
+}
+
+func newTestCert(t *testing.T, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent = template
+		parentKey = key
+	} else if strings.HasPrefix(cn, "server") {
+		template.DNSNames = []string{"localhost"}
+	} else {
+		template.DNSNames = []string{cn + ".local"}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return cert, key
+}
+
+func writeTestPEM(t *testing.T, file string, cert *x509.Certificate, key *ecdsa.PrivateKey) {
+	var block *pem.Block
+	if cert != nil {
+		block = &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}
+	} else {
+		der, err := x509.MarshalECPrivateKey(key)
+		assert.Nil(t, err)
+		block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	}
+	err := ioutil.WriteFile(file, pem.EncodeToMemory(block), 0600)
+	assert.Nil(t, err)
+}
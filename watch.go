@@ -0,0 +1,41 @@
+package ruller
+
+import (
+	"sync"
+)
+
+var (
+	watchMutex    sync.Mutex
+	groupWatchers = make(map[string]map[chan struct{}]bool)
+)
+
+//watchGroup returns a channel that receives a signal whenever the rules of a group change at runtime (kill switches, overrides etc).
+//Signals are coalesced, so a slow watcher gets only one pending signal. Call the returned function to stop watching
+func watchGroup(groupName string) (<-chan struct{}, func()) {
+	watchMutex.Lock()
+	defer watchMutex.Unlock()
+	ch := make(chan struct{}, 1)
+	gw, exists := groupWatchers[groupName]
+	if !exists {
+		gw = make(map[chan struct{}]bool)
+		groupWatchers[groupName] = gw
+	}
+	gw[ch] = true
+	return ch, func() {
+		watchMutex.Lock()
+		defer watchMutex.Unlock()
+		delete(groupWatchers[groupName], ch)
+	}
+}
+
+//notifyGroupChanged signals all watchers of a group
+func notifyGroupChanged(groupName string) {
+	watchMutex.Lock()
+	defer watchMutex.Unlock()
+	for ch := range groupWatchers[groupName] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}